@@ -22,7 +22,9 @@ import (
 
 	"github.com/gravitational/teleport"
 	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/api/types/events"
 	"github.com/gravitational/teleport/lib/auth/native"
+	libevents "github.com/gravitational/teleport/lib/events"
 	"github.com/gravitational/teleport/lib/jwt"
 	"github.com/gravitational/teleport/lib/modules"
 	"github.com/gravitational/teleport/lib/services"
@@ -310,3 +312,101 @@ func (s *Server) CreateSnowflakeSession(ctx context.Context, req types.CreateSno
 
 	return session, nil
 }
+
+// RevokeSnowflakeSession deletes the Snowflake web session identified by
+// sessionID from the backend and emits an audit event, allowing a
+// compromised bearer token to be invalidated before its TTL expires.
+func (s *Server) RevokeSnowflakeSession(ctx context.Context, sessionID string) error {
+	session, err := s.Identity.GetSnowflakeSession(ctx, types.GetSnowflakeSessionRequest{SessionID: sessionID})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	if err := s.Identity.DeleteSnowflakeSession(ctx, types.DeleteSnowflakeSessionRequest{SessionID: sessionID}); err != nil {
+		return trace.Wrap(err)
+	}
+
+	if err := s.emitter.EmitAuditEvent(ctx, &events.SnowflakeSessionRevoke{
+		Metadata: events.Metadata{
+			Type: libevents.SnowflakeSessionRevokeEvent,
+			Code: libevents.SnowflakeSessionRevokeCode,
+		},
+		UserMetadata: events.UserMetadata{
+			User: session.GetUser(),
+		},
+		SessionMetadata: events.SessionMetadata{
+			SessionID: sessionID,
+		},
+	}); err != nil {
+		log.WithError(err).Warn("Failed to emit Snowflake session revoke event.")
+	}
+
+	return nil
+}
+
+// RefreshSnowflakeSession atomically rotates the bearer token and expiry of
+// an existing Snowflake session. It's used when Snowflake issues a new
+// token via an OAUTH_REFRESH response, so the db proxy can keep using the
+// session without forcing the user to reauthenticate.
+func (s *Server) RefreshSnowflakeSession(ctx context.Context, sessionID, newToken string, newTTL time.Duration) (types.WebSession, error) {
+	session, err := s.Identity.GetSnowflakeSession(ctx, types.GetSnowflakeSessionRequest{SessionID: sessionID})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	session.SetBearerToken(newToken)
+	session.SetBearerTokenExpiryTime(s.clock.Now().Add(newTTL))
+
+	if err := s.Identity.UpsertSnowflakeSession(ctx, session); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	log.Debugf("Refreshed Snowflake bearer token for session %v with TTL %v.", sessionID, newTTL)
+
+	return session, nil
+}
+
+// snowflakeSessionReapInterval is how often reapExpiredSnowflakeSessions scans the
+// backend for expired Snowflake sessions.
+const snowflakeSessionReapInterval = 10 * time.Minute
+
+// StartSnowflakeSessionReaper starts a background loop that purges expired
+// Snowflake sessions from the backend, since CreateSnowflakeSession and
+// RefreshSnowflakeSession otherwise leave expiry enforcement solely to TTL
+// checks at read time. The loop runs until ctx is cancelled.
+func (s *Server) StartSnowflakeSessionReaper(ctx context.Context) {
+	go func() {
+		ticker := s.clock.NewTicker(snowflakeSessionReapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.Chan():
+				if err := s.reapExpiredSnowflakeSessions(ctx); err != nil {
+					log.WithError(err).Warn("Failed to purge expired Snowflake sessions.")
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reapExpiredSnowflakeSessions deletes every Snowflake session whose expiry
+// has already passed.
+func (s *Server) reapExpiredSnowflakeSessions(ctx context.Context) error {
+	sessions, err := s.Identity.GetSnowflakeSessions(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	now := s.clock.Now()
+	for _, session := range sessions {
+		if now.Before(session.GetExpiryTime()) {
+			continue
+		}
+		sessionID := session.GetName()
+		if err := s.Identity.DeleteSnowflakeSession(ctx, types.DeleteSnowflakeSessionRequest{SessionID: sessionID}); err != nil && !trace.IsNotFound(err) {
+			log.WithError(err).Warnf("Failed to delete expired Snowflake session %v.", sessionID)
+		}
+	}
+	return nil
+}
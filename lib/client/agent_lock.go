@@ -0,0 +1,58 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"github.com/gravitational/trace"
+)
+
+// Lock locks the in-memory Teleport agent and, if keys were loaded into the
+// system agent, the system agent as well. While locked, both agents refuse
+// to sign with or disclose any of the keys they hold until Unlock is called
+// with the same passphrase. This lets a user freeze use of their certificate
+// (e.g. when stepping away from their desk) without unloading the key
+// material altogether.
+func (a *LocalKeyAgent) Lock(passphrase []byte) error {
+	if err := a.Agent.Lock(passphrase); err != nil {
+		return trace.Wrap(err)
+	}
+
+	if a.sshAgent != nil && (a.keysOption == AddKeysToAgentAuto || a.keysOption == AddKeysToAgentYes) {
+		if err := a.sshAgent.Lock(passphrase); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// Unlock reverses Lock, restoring the ability to sign with and list keys
+// held by the Teleport agent and, if applicable, the system agent. The
+// passphrase must match the one passed to Lock.
+func (a *LocalKeyAgent) Unlock(passphrase []byte) error {
+	if err := a.Agent.Unlock(passphrase); err != nil {
+		return trace.Wrap(err)
+	}
+
+	if a.sshAgent != nil && (a.keysOption == AddKeysToAgentAuto || a.keysOption == AddKeysToAgentYes) {
+		if err := a.sshAgent.Unlock(passphrase); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	return nil
+}
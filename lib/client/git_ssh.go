@@ -0,0 +1,85 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	apisshutils "github.com/gravitational/teleport/api/utils/sshutils"
+	"github.com/gravitational/trace"
+)
+
+// gitSSHWrapperBinary is the name of the thin wrapper binary that
+// GitSSHCommand points `ssh`/`git` at. The wrapper dials SSH_AUTH_SOCK and
+// restricts signing to the Teleport-issued key for the current cluster and
+// user, so a single `GIT_SSH_COMMAND` works regardless of what else is
+// loaded into the agent.
+const gitSSHWrapperBinary = "tsh-git-ssh-wrapper"
+
+// GitSSHCommand returns an `ssh` invocation suitable for use as
+// GIT_SSH_COMMAND, pointed at the Teleport-issued SSH certificate currently
+// loaded into the agent for this cluster and user. It requires that the key
+// was loaded into an agent reachable over SSH_AUTH_SOCK, since the wrapper
+// binary authenticates by dialing that socket and matching the key comment
+// rather than reading key material directly.
+func (a *LocalKeyAgent) GitSSHCommand(ctx context.Context) (string, error) {
+	authSock := os.Getenv("SSH_AUTH_SOCK")
+	if authSock == "" {
+		return "", trace.BadParameter("SSH_AUTH_SOCK is not set; load a key into the agent first")
+	}
+
+	wrapper, err := exec.LookPath(gitSSHWrapperBinary)
+	if err != nil {
+		return "", trace.Wrap(err, "%s must be installed alongside tsh", gitSSHWrapperBinary)
+	}
+
+	comment := apisshutils.TeleportAgentKeyComment(a.siteName, a.username)
+	return fmt.Sprintf("%s -sock %s -key-comment %s -- ssh", wrapper, authSock, comment), nil
+}
+
+// ExportSSHSigningKey writes the currently loaded key's public key and SSH
+// certificate as a `user.pub`/`user-cert.pub` pair under the agent's key
+// directory, in the layout `git config gpg.ssh.program`/`user.signingkey`
+// expects. It returns the path to the certificate file, which is the value
+// that should be set as `user.signingkey`.
+func (a *LocalKeyAgent) ExportSSHSigningKey() (string, error) {
+	key, err := a.GetKey(a.siteName)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	fsStore, ok := a.keyStore.(*FSLocalKeyStore)
+	if !ok {
+		return "", trace.BadParameter("exporting a git signing key requires a filesystem key store")
+	}
+
+	pubPath := filepath.Join(fsStore.KeyDir, "user.pub")
+	certPath := filepath.Join(fsStore.KeyDir, "user-cert.pub")
+
+	if err := os.WriteFile(pubPath, key.Pub, 0600); err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	if err := os.WriteFile(certPath, key.Cert, 0600); err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+
+	return certPath, nil
+}
@@ -495,6 +495,80 @@ func TestLocalKeyAgent_AddDatabaseKey(t *testing.T) {
 	})
 }
 
+// TestLocalKeyAgent_ExportSSHSigningKey ensures that the exported
+// user.pub/user-cert.pub pair validate against the cluster user CA, so they
+// can be handed to `git config user.signingkey`.
+func TestLocalKeyAgent_ExportSSHSigningKey(t *testing.T) {
+	s := makeSuite(t)
+	lka := s.newKeyAgent(t)
+
+	_, err := lka.AddKey(s.key)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		err = lka.UnloadKey(s.key.KeyIndex)
+		require.NoError(t, err)
+	})
+
+	certPath, err := lka.ExportSSHSigningKey()
+	require.NoError(t, err)
+	require.FileExists(t, certPath)
+
+	certBytes, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	require.NoError(t, err)
+
+	cert, ok := pub.(*ssh.Certificate)
+	require.True(t, ok, "expected an ssh certificate")
+	require.Equal(t, s.username, cert.KeyId)
+
+	// the certificate must be signed by the user CA that makeKey used.
+	caSigner, err := ssh.ParsePrivateKey(fixtures.PEMBytes["rsa"])
+	require.NoError(t, err)
+	require.Equal(t, caSigner.PublicKey().Marshal(), cert.SignatureKey.Marshal())
+}
+
+// TestLocalKeyAgent_LockUnlock ensures that locking a LocalKeyAgent prevents
+// both the teleport agent and the system agent from signing, and that
+// unlocking with the same passphrase restores signing.
+func TestLocalKeyAgent_LockUnlock(t *testing.T) {
+	s := makeSuite(t)
+	lka := s.newKeyAgent(t)
+
+	_, err := lka.AddKey(s.key)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		err = lka.UnloadKey(s.key.KeyIndex)
+		require.NoError(t, err)
+	})
+
+	teleportAgentKeys, err := lka.Agent.List()
+	require.NoError(t, err)
+	require.NotEmpty(t, teleportAgentKeys)
+
+	// a locked agent lists no keys, so list the system agent's keys first.
+	systemAgentKeys, err := lka.sshAgent.List()
+	require.NoError(t, err)
+	require.NotEmpty(t, systemAgentKeys)
+
+	passphrase := []byte("super-secret")
+	require.NoError(t, lka.Lock(passphrase))
+
+	// while locked, neither agent should be willing to sign.
+	_, err = lka.Agent.Sign(teleportAgentKeys[0], []byte("hello, world"))
+	require.Error(t, err)
+
+	_, err = lka.sshAgent.Sign(systemAgentKeys[0], []byte("hello, world"))
+	require.Error(t, err)
+
+	// unlocking with the same passphrase should restore signing.
+	require.NoError(t, lka.Unlock(passphrase))
+	_, err = lka.Agent.Sign(teleportAgentKeys[0], []byte("hello, world"))
+	require.NoError(t, err)
+	_, err = lka.sshAgent.Sign(systemAgentKeys[0], []byte("hello, world"))
+	require.NoError(t, err)
+}
+
 func (s *KeyAgentTestSuite) makeKey(t *testing.T, username, clusterName string) *Key {
 	keygen := testauthority.New()
 	ttl := time.Minute
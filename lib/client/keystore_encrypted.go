@@ -0,0 +1,434 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/scrypt"
+)
+
+// kekCanary is written into every encrypted private key header and checked
+// on decrypt. Because CBC-mode decryption with the wrong key can "succeed"
+// and simply produce garbage, an authenticated canary is the only reliable
+// way to tell "wrong passphrase" apart from "corrupt file".
+var kekCanary = []byte("teleport-kek-v1")
+
+const (
+	kekSaltLen = 32
+	kekKeyLen  = 32
+	// kekVersion is the format version written into, and authenticated by
+	// the HMAC of, every encrypted private key header. It is a constant:
+	// RotateKEK re-wraps keys under the same version.
+	kekVersion = 1
+)
+
+// EncryptedFSLocalKeyStore is a LocalKeyStore that wraps FSLocalKeyStore,
+// encrypting the private key material on disk with a key-encryption-key
+// (KEK) derived from a user-supplied passphrase. TLS and SSH certificates
+// are left in the clear, as in FSLocalKeyStore, since they contain no
+// secret material and must remain readable by OpenSSH/TLS tooling.
+type EncryptedFSLocalKeyStore struct {
+	*FSLocalKeyStore
+
+	// kek is the key-encryption-key derived from the user's passphrase. It
+	// is cached in memory for the life of the agent process so the user is
+	// only prompted once, at `tsh login` time.
+	kek []byte
+}
+
+// kekIndex is the on-disk record of every KeyIndex this store has ever
+// encrypted a private key under, so RotateKEK knows what to re-wrap without
+// FSLocalKeyStore needing to expose its own key enumeration.
+type kekIndex struct {
+	Entries []KeyIndex
+}
+
+// NewEncryptedFSLocalKeyStore creates a new encrypted filesystem key store,
+// deriving a KEK from passphrase for use when reading and writing private
+// keys under dirPath.
+func NewEncryptedFSLocalKeyStore(dirPath string, passphrase []byte) (*EncryptedFSLocalKeyStore, error) {
+	fsStore, err := NewFSLocalKeyStore(dirPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	salt, err := readOrCreateKEKSalt(fsStore.KeyDir)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	kek, err := deriveKEK(passphrase, salt)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &EncryptedFSLocalKeyStore{
+		FSLocalKeyStore: fsStore,
+		kek:             kek,
+	}, nil
+}
+
+// kekSaltPath returns the path of the salt sidecar file stored alongside the
+// rest of the profile's keys.
+func kekSaltPath(keyDir string) string {
+	return keyDir + "/kek_salt"
+}
+
+func readOrCreateKEKSalt(keyDir string) ([]byte, error) {
+	path := kekSaltPath(keyDir)
+	salt, err := os.ReadFile(path)
+	if err == nil {
+		return salt, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, trace.ConvertSystemError(err)
+	}
+
+	salt = make([]byte, kekSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := os.WriteFile(path, salt, 0600); err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	return salt, nil
+}
+
+// deriveKEK derives a key-encryption-key from passphrase and salt using
+// scrypt, the same construction swarmkit uses to wrap its manager keys.
+func deriveKEK(passphrase, salt []byte) ([]byte, error) {
+	kek, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, kekKeyLen)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return kek, nil
+}
+
+// encryptPrivateKey wraps a raw private key with the KEK, producing
+// version || hmac(canary || version) || iv || ciphertext.
+func encryptPrivateKey(kek, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	padded := pkcs7Pad(plaintext, aes.BlockSize)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, kekVersion)
+
+	mac := hmac.New(sha256.New, kek)
+	mac.Write(kekCanary)
+	mac.Write(header)
+	digest := mac.Sum(nil)
+
+	out := make([]byte, 0, len(header)+len(digest)+len(iv)+len(ciphertext))
+	out = append(out, header...)
+	out = append(out, digest...)
+	out = append(out, iv...)
+	out = append(out, ciphertext...)
+	return out, nil
+}
+
+// decryptPrivateKey reverses encryptPrivateKey, returning a trace.AccessDenied
+// error if the passphrase-derived KEK doesn't match the authenticated header,
+// so callers can distinguish a wrong passphrase from a corrupt file.
+func decryptPrivateKey(kek, encoded []byte) ([]byte, error) {
+	const headerLen, digestLen = 4, sha256.Size
+	if len(encoded) < headerLen+digestLen+aes.BlockSize {
+		return nil, trace.BadParameter("encrypted key is truncated")
+	}
+
+	header := encoded[:headerLen]
+	digest := encoded[headerLen : headerLen+digestLen]
+	iv := encoded[headerLen+digestLen : headerLen+digestLen+aes.BlockSize]
+	ciphertext := encoded[headerLen+digestLen+aes.BlockSize:]
+
+	mac := hmac.New(sha256.New, kek)
+	mac.Write(kekCanary)
+	mac.Write(header)
+	expected := mac.Sum(nil)
+	if subtle.ConstantTimeCompare(expected, digest) != 1 {
+		return nil, trace.AccessDenied("wrong passphrase or corrupt key file")
+	}
+
+	if len(ciphertext)%aes.BlockSize != 0 {
+		return nil, trace.BadParameter("encrypted key is corrupt")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	return pkcs7Unpad(plaintext)
+}
+
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padLen := blockSize - len(data)%blockSize
+	padding := make([]byte, padLen)
+	for i := range padding {
+		padding[i] = byte(padLen)
+	}
+	return append(append([]byte{}, data...), padding...)
+}
+
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, trace.BadParameter("encrypted key is empty")
+	}
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > len(data) {
+		return nil, trace.BadParameter("encrypted key has invalid padding")
+	}
+	return data[:len(data)-padLen], nil
+}
+
+// RotateKEK re-derives the KEK from newPassphrase and re-encrypts every
+// on-disk private key this store has ever written under it, so the keys
+// remain readable after the rotation instead of being bricked under a salt
+// nothing can derive the old KEK from any more. It returns an AccessDenied
+// error, without touching anything on disk, if oldPassphrase does not derive
+// the store's current KEK.
+//
+// The outgoing KEK is stashed (see kekPrevPath) before any key is touched
+// and only removed once every key has been confirmed re-encrypted, so a
+// crash partway through leaves GetKey able to recover: keys already
+// rewrapped decrypt with the new KEK, and keys not yet reached still decrypt
+// via the stash, which GetKey tries as a fallback and uses to opportunistically
+// finish the migration.
+func (s *EncryptedFSLocalKeyStore) RotateKEK(oldPassphrase, newPassphrase []byte) error {
+	oldSalt := mustReadKEKSalt(s.KeyDir)
+	oldKEK, err := deriveKEK(oldPassphrase, oldSalt)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if subtle.ConstantTimeCompare(oldKEK, s.kek) != 1 {
+		return trace.AccessDenied("old passphrase does not match the current keystore passphrase")
+	}
+
+	idx, err := readKEKIndex(s.KeyDir)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	salt := make([]byte, kekSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return trace.Wrap(err)
+	}
+	newKEK, err := deriveKEK(newPassphrase, salt)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	if err := os.WriteFile(kekPrevPath(s.KeyDir), oldKEK, 0600); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	tmp := kekSaltPath(s.KeyDir) + ".tmp"
+	if err := os.WriteFile(tmp, salt, 0600); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	if err := os.Rename(tmp, kekSaltPath(s.KeyDir)); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	s.kek = newKEK
+
+	for _, ki := range idx.Entries {
+		if err := s.rewrapKey(ki, oldKEK, newKEK); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	if err := os.Remove(kekPrevPath(s.KeyDir)); err != nil && !os.IsNotExist(err) {
+		return trace.ConvertSystemError(err)
+	}
+	return nil
+}
+
+// rewrapKey re-encrypts a single on-disk key from oldKEK to newKEK. It
+// tolerates being re-run against a key RotateKEK already migrated in a
+// previous, interrupted attempt by falling back to decrypting with newKEK.
+func (s *EncryptedFSLocalKeyStore) rewrapKey(idx KeyIndex, oldKEK, newKEK []byte) error {
+	key, err := s.FSLocalKeyStore.GetKey(idx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	plaintext, err := decryptPrivateKey(oldKEK, key.Priv)
+	if err != nil {
+		plaintext, err = decryptPrivateKey(newKEK, key.Priv)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		// Already migrated by a previous, interrupted rotation attempt.
+		return nil
+	}
+
+	reEncrypted, err := encryptPrivateKey(newKEK, plaintext)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	key.Priv = reEncrypted
+	return trace.Wrap(s.FSLocalKeyStore.AddKey(key))
+}
+
+func mustReadKEKSalt(keyDir string) []byte {
+	salt, err := os.ReadFile(kekSaltPath(keyDir))
+	if err != nil {
+		return nil
+	}
+	return salt
+}
+
+// kekIndexPath returns the path of the sidecar file tracking every KeyIndex
+// AddKey has encrypted a private key under, so RotateKEK can find them all
+// again without FSLocalKeyStore needing its own enumeration API.
+func kekIndexPath(keyDir string) string {
+	return keyDir + "/kek_index"
+}
+
+// kekPrevPath returns the path of the sidecar file RotateKEK uses to stash
+// the outgoing KEK while it re-wraps on-disk keys, so GetKey can still
+// recover a key that RotateKEK touched before a crash interrupted the
+// rotation. It holds raw key-encryption-key bytes rather than a passphrase,
+// so it only exists for the (short) duration of a rotation and is removed
+// once RotateKEK finishes.
+func kekPrevPath(keyDir string) string {
+	return keyDir + "/kek_prev"
+}
+
+func readKEKIndex(keyDir string) (kekIndex, error) {
+	data, err := os.ReadFile(kekIndexPath(keyDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return kekIndex{}, nil
+		}
+		return kekIndex{}, trace.ConvertSystemError(err)
+	}
+
+	var idx kekIndex
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&idx); err != nil {
+		return kekIndex{}, trace.Wrap(err)
+	}
+	return idx, nil
+}
+
+// recordKEKIndex adds idx to the sidecar index file, if it isn't already
+// tracked, so a future RotateKEK knows to re-wrap this key too.
+func recordKEKIndex(keyDir string, idx KeyIndex) error {
+	existing, err := readKEKIndex(keyDir)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, e := range existing.Entries {
+		if e == idx {
+			return nil
+		}
+	}
+	existing.Entries = append(existing.Entries, idx)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(existing); err != nil {
+		return trace.Wrap(err)
+	}
+
+	tmp := kekIndexPath(keyDir) + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	return trace.ConvertSystemError(os.Rename(tmp, kekIndexPath(keyDir)))
+}
+
+// AddKey encrypts key's private key material with the store's KEK before
+// handing off to FSLocalKeyStore, so the cleartext private key is never
+// written to disk, and records key's index so a future RotateKEK knows to
+// re-wrap it.
+func (s *EncryptedFSLocalKeyStore) AddKey(key *Key) error {
+	encrypted, err := encryptPrivateKey(s.kek, key.Priv)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	encryptedKey := *key
+	encryptedKey.Priv = encrypted
+	if err := s.FSLocalKeyStore.AddKey(&encryptedKey); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(recordKEKIndex(s.KeyDir, key.KeyIndex))
+}
+
+// GetKey loads the key from disk via FSLocalKeyStore and decrypts its
+// private key material with the store's KEK, reversing AddKey.
+//
+// If decrypting with the current KEK fails, it falls back to the KEK
+// RotateKEK stashes at kekPrevPath while a rotation is in progress, covering
+// the case where this key hasn't been re-wrapped yet because a previous
+// RotateKEK call was interrupted. A successful fallback decrypt
+// opportunistically re-encrypts the key under the current KEK so the
+// fallback isn't needed again.
+func (s *EncryptedFSLocalKeyStore) GetKey(idx KeyIndex, opts ...CertOption) (*Key, error) {
+	key, err := s.FSLocalKeyStore.GetKey(idx, opts...)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	decrypted, err := decryptPrivateKey(s.kek, key.Priv)
+	if err == nil {
+		key.Priv = decrypted
+		return key, nil
+	}
+
+	prevKEK, prevErr := os.ReadFile(kekPrevPath(s.KeyDir))
+	if prevErr != nil {
+		return nil, trace.Wrap(err)
+	}
+	decrypted, prevErr = decryptPrivateKey(prevKEK, key.Priv)
+	if prevErr != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if reEncrypted, encErr := encryptPrivateKey(s.kek, decrypted); encErr == nil {
+		healedKey := *key
+		healedKey.Priv = reEncrypted
+		_ = s.FSLocalKeyStore.AddKey(&healedKey)
+	}
+
+	key.Priv = decrypted
+	return key, nil
+}
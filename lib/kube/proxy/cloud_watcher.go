@@ -28,74 +28,167 @@ import (
 	"github.com/gravitational/trace"
 )
 
+// Watcher discovers EKS, AKS, and GKE clusters via a Reflector per
+// matcher/region (or subscription, or project) and fans every
+// Added/Updated/Deleted/Sync delta into a single channel as a fresh
+// snapshot of every cluster currently known across all of them.
 type Watcher struct {
-	// Instances can be used to consume
-	Instances chan []*eks.Cluster
+	// Instances can be used to consume the current snapshot of discovered
+	// clusters whenever any reflector's store changes.
+	Instances chan []*DiscoveredKubeCluster
 
-	fetchers []fetcher
-	waitTime time.Duration
-	ctx      context.Context
-	cancel   context.CancelFunc
+	reflectors  []*Reflector
+	subscribers []ResourceEventHandler
+	waitTime    time.Duration
+	ctx         context.Context
+	cancel      context.CancelFunc
 }
 
+// Subscribe registers an additional ResourceEventHandler that is notified
+// of every delta alongside the Watcher's own Instances snapshot, so e.g. a
+// Reporter can reconcile kube_server resources without polling Instances
+// itself.
+func (w *Watcher) Subscribe(handler ResourceEventHandler) {
+	w.subscribers = append(w.subscribers, handler)
+}
+
+// Start runs every reflector in its own goroutine until Stop is called.
 func (w *Watcher) Start() {
-	ticker := time.NewTicker(w.waitTime)
-	for {
-		for _, fetcher := range w.fetchers {
-			_, err := fetcher.GetKubeClusters(w.ctx)
-			if err != nil {
-				log.Error("Failed to fetch EC2 instances: ", err)
-				continue
-			}
-			//	w.Instances <- inst
-		}
-		select {
-		case <-ticker.C:
-			continue
-		case <-w.ctx.Done():
-			return
-		}
+	var wg sync.WaitGroup
+	for _, r := range w.reflectors {
+		wg.Add(1)
+		go func(r *Reflector) {
+			defer wg.Done()
+			r.Run(w.ctx)
+		}(r)
 	}
+	wg.Wait()
 }
 
 func (w *Watcher) Stop() {
 	w.cancel()
 }
 
-func NewCloudServerWatcher(ctx context.Context, matchers []services.AWSMatcher, clients common.CloudClients) (*Watcher, error) {
+// OnAdd implements ResourceEventHandler.
+func (w *Watcher) OnAdd(cluster *DiscoveredKubeCluster) {
+	w.publish()
+	for _, h := range w.subscribers {
+		h.OnAdd(cluster)
+	}
+}
+
+// OnUpdate implements ResourceEventHandler.
+func (w *Watcher) OnUpdate(oldCluster, newCluster *DiscoveredKubeCluster) {
+	w.publish()
+	for _, h := range w.subscribers {
+		h.OnUpdate(oldCluster, newCluster)
+	}
+}
+
+// OnDelete implements ResourceEventHandler.
+func (w *Watcher) OnDelete(cluster *DiscoveredKubeCluster) {
+	w.publish()
+	for _, h := range w.subscribers {
+		h.OnDelete(cluster)
+	}
+}
+
+// publish sends the union of every reflector's current store to Instances,
+// dropping the send if a consumer isn't keeping up rather than blocking the
+// reflector that triggered it.
+func (w *Watcher) publish() {
+	var snapshot []*DiscoveredKubeCluster
+	for _, r := range w.reflectors {
+		snapshot = append(snapshot, r.List()...)
+	}
+	select {
+	case w.Instances <- snapshot:
+	default:
+	}
+}
+
+// HasSynced reports whether every reflector has completed at least one full
+// poll of its fetcher.
+func (w *Watcher) HasSynced() bool {
+	for _, r := range w.reflectors {
+		if !r.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Matchers bundles the per-cloud matcher configs NewCloudServerWatcher fans
+// out over, mirroring how discovery configs group AWS/Azure/GCP sections
+// today.
+type Matchers struct {
+	AWS   []services.AWSMatcher
+	Azure []services.AzureMatcher
+	GCP   []services.GCPMatcher
+}
+
+// NewCloudServerWatcher creates a Watcher with one Reflector per
+// (cloud, region/subscription/project) combination described by matchers.
+func NewCloudServerWatcher(ctx context.Context, matchers Matchers, clients common.CloudClients) (*Watcher, error) {
 	cancelCtx, cancelFn := context.WithCancel(ctx)
 	watcher := Watcher{
-		fetchers:  []fetcher{},
-		ctx:       cancelCtx,
-		cancel:    cancelFn,
-		waitTime:  time.Minute,
-		Instances: make(chan []*eks.Cluster),
+		reflectors: []*Reflector{},
+		ctx:        cancelCtx,
+		cancel:     cancelFn,
+		waitTime:   time.Minute,
+		Instances:  make(chan []*DiscoveredKubeCluster, 1),
 	}
-	for _, matcher := range matchers {
+
+	for _, matcher := range matchers.AWS {
 		for _, region := range matcher.Regions {
 			cl, err := clients.GetAWSEKSClient(region)
 			if err != nil {
 				return nil, trace.Wrap(err)
 			}
-			fetcher, err := newEKSClusterFetcher(matcher, region, cl)
+			f, err := newEKSClusterFetcher(matcher, region, cl)
 			if err != nil {
 				return nil, trace.Wrap(err)
 			}
-			watcher.fetchers = append(watcher.fetchers, fetcher)
+			watcher.reflectors = append(watcher.reflectors, NewReflector(f, &watcher, watcher.waitTime))
 		}
 	}
+
+	for _, matcher := range matchers.Azure {
+		for _, subscription := range matcher.Subscriptions {
+			cl, err := clients.GetAzureAKSClient(subscription)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			f := newAKSClusterFetcher(matcher, subscription, cl)
+			watcher.reflectors = append(watcher.reflectors, NewReflector(f, &watcher, watcher.waitTime))
+		}
+	}
+
+	for _, matcher := range matchers.GCP {
+		for _, project := range matcher.ProjectIDs {
+			cl, err := clients.GetGCPContainerClient(project)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			f := newGKEClusterFetcher(matcher, project, cl)
+			watcher.reflectors = append(watcher.reflectors, NewReflector(f, &watcher, watcher.waitTime))
+		}
+	}
+
 	return &watcher, nil
 }
 
+// fetcher produces the current snapshot of clusters matching a single
+// cloud/region/subscription/project combination, normalized to the
+// cloud-agnostic DiscoveredKubeCluster.
 type fetcher interface {
-	GetKubeClusters(context.Context) ([]*kubeCreds, error)
+	GetKubeClusters(context.Context) ([]*DiscoveredKubeCluster, error)
 }
 
 type eksClusterFetcher struct {
 	filterLabels types.Labels
 	eksClient    eksiface.EKSAPI
 	region       string
-	mu           sync.Mutex
 }
 
 func newEKSClusterFetcher(matcher services.AWSMatcher, region string, eksClient eksiface.EKSAPI) (*eksClusterFetcher, error) {
@@ -103,52 +196,36 @@ func newEKSClusterFetcher(matcher services.AWSMatcher, region string, eksClient
 		eksClient:    eksClient,
 		filterLabels: matcher.Tags,
 		region:       region,
-		//	cache:        map[string]string{},
 	}
 	return &fetcherConfig, nil
 }
 
-func (f *eksClusterFetcher) GetKubeClusters(ctx context.Context) ([]*kubeCreds, error) {
+func (f *eksClusterFetcher) GetKubeClusters(ctx context.Context) ([]*DiscoveredKubeCluster, error) {
 	type clusterResponse struct {
 		cluster *eks.Cluster
 		err     error
 	}
-	var (
-		clusterResponseChan chan clusterResponse
-	)
+
+	clusterResponseChan := make(chan clusterResponse)
+	var wg sync.WaitGroup
+
 	err := f.eksClient.ListClustersPagesWithContext(ctx,
 		&eks.ListClustersInput{},
-		func(lCusters *eks.ListClustersOutput, lastPage bool) bool {
-			wg := &sync.WaitGroup{}
-			wg.Add(len(lCusters.Clusters))
-			for i := 0; i < len(lCusters.Clusters); i++ {
-				eksClusterName := lCusters.Clusters[i]
-
-				go func() {
+		func(lClusters *eks.ListClustersOutput, lastPage bool) bool {
+			for _, eksClusterName := range lClusters.Clusters {
+				wg.Add(1)
+				go func(name *string) {
+					defer wg.Done()
 					cluster, err := f.eksClient.DescribeClusterWithContext(
 						ctx,
-						&eks.DescribeClusterInput{
-							Name: aws.String(*eksClusterName),
-						},
+						&eks.DescribeClusterInput{Name: name},
 					)
-
 					if err != nil {
-						clusterResponseChan <- clusterResponse{
-							cluster: nil,
-							err:     err,
-						}
+						clusterResponseChan <- clusterResponse{err: err}
 						return
 					}
-
-					clusterResponseChan <- clusterResponse{
-						cluster: cluster.Cluster,
-						err:     nil,
-					}
-				}()
-			}
-			wg.Done()
-			if lastPage {
-				close(clusterResponseChan)
+					clusterResponseChan <- clusterResponse{cluster: cluster.Cluster}
+				}(eksClusterName)
 			}
 			return true
 		},
@@ -156,10 +233,16 @@ func (f *eksClusterFetcher) GetKubeClusters(ctx context.Context) ([]*kubeCreds,
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
+
+	go func() {
+		wg.Wait()
+		close(clusterResponseChan)
+	}()
+
 	var result []*eks.Cluster
 	for clusterRsp := range clusterResponseChan {
 		if clusterRsp.err != nil {
-			// TODO: log me here
+			log.Error("Failed to describe EKS cluster: ", clusterRsp.err)
 			continue
 		}
 		cluster := clusterRsp.cluster
@@ -171,11 +254,10 @@ func (f *eksClusterFetcher) GetKubeClusters(ctx context.Context) ([]*kubeCreds,
 		if !match {
 			continue
 		}
-		result = append(result, cluster)
+		result = append(result, newDiscoveredEKSCluster(f.region, cluster, clusterLabels))
 	}
 
-	_ = result
-	return nil, nil
+	return result, nil
 }
 
 func eksTagsToLabels(tags map[string]*string) map[string]string {
@@ -189,3 +271,101 @@ func eksTagsToLabels(tags map[string]*string) map[string]string {
 	}
 	return labels
 }
+
+// aksClusterFetcher discovers AKS clusters in a single Azure subscription
+// matching an AzureMatcher, the AKS equivalent of eksClusterFetcher.
+type aksClusterFetcher struct {
+	filterLabels   types.Labels
+	aksClient      common.AKSClient
+	subscriptionID string
+}
+
+func newAKSClusterFetcher(matcher services.AzureMatcher, subscriptionID string, aksClient common.AKSClient) *aksClusterFetcher {
+	return &aksClusterFetcher{
+		aksClient:      aksClient,
+		filterLabels:   matcher.Tags,
+		subscriptionID: subscriptionID,
+	}
+}
+
+func (f *aksClusterFetcher) GetKubeClusters(ctx context.Context) ([]*DiscoveredKubeCluster, error) {
+	clusters, err := f.aksClient.ListClusters(ctx, f.subscriptionID)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var result []*DiscoveredKubeCluster
+	for _, cluster := range clusters {
+		clusterLabels := azureTagsToLabels(cluster.Tags)
+		match, _, err := services.MatchLabels(f.filterLabels, clusterLabels)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if !match {
+			continue
+		}
+		result = append(result, newDiscoveredAKSCluster(cluster, clusterLabels))
+	}
+	return result, nil
+}
+
+func azureTagsToLabels(tags map[string]string) map[string]string {
+	labels := make(map[string]string)
+	for key, value := range tags {
+		if types.IsValidLabelKey(key) {
+			labels[key] = value
+		} else {
+			log.Debugf("Skipping AKS tag %q, not a valid label key", key)
+		}
+	}
+	return labels
+}
+
+// gkeClusterFetcher discovers GKE clusters in a single GCP project matching
+// a GCPMatcher, the GKE equivalent of eksClusterFetcher.
+type gkeClusterFetcher struct {
+	filterLabels types.Labels
+	gkeClient    common.GKEClient
+	projectID    string
+}
+
+func newGKEClusterFetcher(matcher services.GCPMatcher, projectID string, gkeClient common.GKEClient) *gkeClusterFetcher {
+	return &gkeClusterFetcher{
+		gkeClient:    gkeClient,
+		filterLabels: matcher.Tags,
+		projectID:    projectID,
+	}
+}
+
+func (f *gkeClusterFetcher) GetKubeClusters(ctx context.Context) ([]*DiscoveredKubeCluster, error) {
+	clusters, err := f.gkeClient.ListClusters(ctx, f.projectID)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var result []*DiscoveredKubeCluster
+	for _, cluster := range clusters {
+		clusterLabels := gcpTagsToLabels(cluster.Tags)
+		match, _, err := services.MatchLabels(f.filterLabels, clusterLabels)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if !match {
+			continue
+		}
+		result = append(result, newDiscoveredGKECluster(cluster, clusterLabels))
+	}
+	return result, nil
+}
+
+func gcpTagsToLabels(tags map[string]string) map[string]string {
+	labels := make(map[string]string)
+	for key, value := range tags {
+		if types.IsValidLabelKey(key) {
+			labels[key] = value
+		} else {
+			log.Debugf("Skipping GKE tag %q, not a valid label key", key)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022 Gravitational, Inc.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+// KubeCloudProvider discriminates which cloud a DiscoveredKubeCluster was
+// fetched from.
+type KubeCloudProvider string
+
+const (
+	KubeCloudAWS   KubeCloudProvider = "AWS"
+	KubeCloudAzure KubeCloudProvider = "Azure"
+	KubeCloudGCP   KubeCloudProvider = "GCP"
+)
+
+// AKSCluster is the minimal set of fields the kube proxy needs about an
+// Azure AKS cluster.
+type AKSCluster struct {
+	SubscriptionID string
+	ResourceGroup  string
+	Name           string
+	Status         string
+	Endpoint       string
+	Version        string
+	Tags           map[string]string
+}
+
+// GKECluster is the minimal set of fields the kube proxy needs about a GCP
+// GKE cluster.
+type GKECluster struct {
+	ProjectID string
+	Location  string
+	Name      string
+	Status    string
+	Endpoint  string
+	Version   string
+	Tags      map[string]string
+}
+
+// DiscoveredKubeCluster is a cloud-agnostic view of a managed Kubernetes
+// cluster, normalizing the handful of fields the Reflector needs to track
+// regardless of which cloud API described it. Exactly one of EKS/AKS/GKE is
+// set, selected by Cloud.
+type DiscoveredKubeCluster struct {
+	// Cloud is the cloud the cluster was discovered in.
+	Cloud KubeCloudProvider
+	// Name is the cluster name, unique within Cloud/Region/Account.
+	Name string
+	// Region is the AWS region or GCP location the cluster lives in; for
+	// AKS clusters it holds the Azure resource group.
+	Region string
+	// AccountID is the AWS account ID, Azure subscription ID, or GCP
+	// project ID that owns the cluster.
+	AccountID string
+	Status    string
+	Endpoint  string
+	Version   string
+	Labels    map[string]string
+
+	EKS *eks.Cluster
+	AKS *AKSCluster
+	GKE *GKECluster
+}
+
+// Key uniquely identifies a cluster across polls, regardless of which
+// cloud it was discovered in.
+func (d *DiscoveredKubeCluster) Key() string {
+	return string(d.Cloud) + "/" + d.Region + "/" + d.AccountID + "/" + d.Name
+}
+
+func newDiscoveredEKSCluster(region string, cluster *eks.Cluster, labels map[string]string) *DiscoveredKubeCluster {
+	var accountID string
+	if parsed, err := arn.Parse(aws.StringValue(cluster.Arn)); err == nil {
+		accountID = parsed.AccountID
+	}
+	return &DiscoveredKubeCluster{
+		Cloud:     KubeCloudAWS,
+		Name:      aws.StringValue(cluster.Name),
+		Region:    region,
+		AccountID: accountID,
+		Status:    aws.StringValue(cluster.Status),
+		Endpoint:  aws.StringValue(cluster.Endpoint),
+		Version:   aws.StringValue(cluster.Version),
+		Labels:    labels,
+		EKS:       cluster,
+	}
+}
+
+func newDiscoveredAKSCluster(cluster *AKSCluster, labels map[string]string) *DiscoveredKubeCluster {
+	return &DiscoveredKubeCluster{
+		Cloud:     KubeCloudAzure,
+		Name:      cluster.Name,
+		Region:    cluster.ResourceGroup,
+		AccountID: cluster.SubscriptionID,
+		Status:    cluster.Status,
+		Endpoint:  cluster.Endpoint,
+		Version:   cluster.Version,
+		Labels:    labels,
+		AKS:       cluster,
+	}
+}
+
+func newDiscoveredGKECluster(cluster *GKECluster, labels map[string]string) *DiscoveredKubeCluster {
+	return &DiscoveredKubeCluster{
+		Cloud:     KubeCloudGCP,
+		Name:      cluster.Name,
+		Region:    cluster.Location,
+		AccountID: cluster.ProjectID,
+		Status:    cluster.Status,
+		Endpoint:  cluster.Endpoint,
+		Version:   cluster.Version,
+		Labels:    labels,
+		GKE:       cluster,
+	}
+}
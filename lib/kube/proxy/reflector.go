@@ -0,0 +1,312 @@
+/*
+Copyright 2022 Gravitational, Inc.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/log"
+)
+
+// DeltaType describes how a cluster in a Reflector's store changed between
+// two polls of its fetcher.
+type DeltaType string
+
+const (
+	// Added means the cluster was not previously in the store.
+	Added DeltaType = "Added"
+	// Updated means the cluster was already in the store and its contents
+	// changed.
+	Updated DeltaType = "Updated"
+	// Deleted means the cluster was in the store but is no longer returned
+	// by the fetcher.
+	Deleted DeltaType = "Deleted"
+	// Sync means the cluster was already in the store and reappeared
+	// unchanged in a periodic poll, letting consumers periodically
+	// reconfirm state without treating it as a real update.
+	Sync DeltaType = "Sync"
+)
+
+// Delta is a single change to a cluster, as computed by Reflector.resync.
+type Delta struct {
+	Type    DeltaType
+	Cluster *DiscoveredKubeCluster
+	// Old is the previously stored cluster, set only for Updated deltas.
+	Old *DiscoveredKubeCluster
+}
+
+// ResourceEventHandler is notified as a Reflector's store changes.
+type ResourceEventHandler interface {
+	// OnAdd is called when a cluster is seen for the first time.
+	OnAdd(cluster *DiscoveredKubeCluster)
+	// OnUpdate is called when a previously seen cluster changes, and also
+	// for periodic Sync deltas, in which case oldCluster and newCluster are
+	// the same object.
+	OnUpdate(oldCluster, newCluster *DiscoveredKubeCluster)
+	// OnDelete is called when a previously seen cluster stops being
+	// returned by the fetcher.
+	OnDelete(cluster *DiscoveredKubeCluster)
+}
+
+// threadSafeStore is a concurrency-safe, keyed cache of the clusters a
+// Reflector last saw.
+type threadSafeStore struct {
+	mu    sync.RWMutex
+	items map[string]*DiscoveredKubeCluster
+}
+
+func newThreadSafeStore() *threadSafeStore {
+	return &threadSafeStore{items: map[string]*DiscoveredKubeCluster{}}
+}
+
+// List returns every cluster currently in the store.
+func (s *threadSafeStore) List() []*DiscoveredKubeCluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*DiscoveredKubeCluster, 0, len(s.items))
+	for _, cluster := range s.items {
+		out = append(out, cluster)
+	}
+	return out
+}
+
+// GetByKey returns the cluster stored under key, if any.
+func (s *threadSafeStore) GetByKey(key string) (*DiscoveredKubeCluster, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cluster, ok := s.items[key]
+	return cluster, ok
+}
+
+func (s *threadSafeStore) replace(key string, cluster *DiscoveredKubeCluster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = cluster
+}
+
+func (s *threadSafeStore) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+}
+
+// deltaFIFO is a FIFO queue of per-cluster deltas, decoupling how often a
+// Reflector polls its fetcher from how quickly deltas are dispatched to a
+// ResourceEventHandler.
+type deltaFIFO struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []string
+	items  map[string][]Delta
+	closed bool
+}
+
+func newDeltaFIFO() *deltaFIFO {
+	f := &deltaFIFO{items: map[string][]Delta{}}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *deltaFIFO) push(key string, d Delta) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.items[key]; !exists {
+		f.queue = append(f.queue, key)
+	}
+	f.items[key] = append(f.items[key], d)
+	f.cond.Signal()
+}
+
+// pop blocks until a key has pending deltas, or the FIFO is closed, and
+// returns that key's deltas in the order they were pushed.
+func (f *deltaFIFO) pop() (string, []Delta, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.queue) == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if len(f.queue) == 0 {
+		return "", nil, false
+	}
+	key := f.queue[0]
+	f.queue = f.queue[1:]
+	deltas := f.items[key]
+	delete(f.items, key)
+	return key, deltas, true
+}
+
+func (f *deltaFIFO) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+}
+
+// Reflector polls a fetcher on its own ticker, diffs the results against a
+// thread-safe store to compute Added/Updated/Deleted/Sync deltas, and
+// dispatches them to a ResourceEventHandler through a deltaFIFO so a slow
+// handler never blocks the next poll.
+type Reflector struct {
+	fetcher   fetcher
+	handler   ResourceEventHandler
+	store     *threadSafeStore
+	fifo      *deltaFIFO
+	waitTime  time.Duration
+	hasSynced bool
+	mu        sync.Mutex
+}
+
+// NewReflector creates a Reflector that polls fetcher every waitTime and
+// dispatches deltas to handler.
+func NewReflector(fetcher fetcher, handler ResourceEventHandler, waitTime time.Duration) *Reflector {
+	if waitTime <= 0 {
+		waitTime = time.Minute
+	}
+	return &Reflector{
+		fetcher:  fetcher,
+		handler:  handler,
+		store:    newThreadSafeStore(),
+		fifo:     newDeltaFIFO(),
+		waitTime: waitTime,
+	}
+}
+
+// List returns every cluster currently in the Reflector's store.
+func (r *Reflector) List() []*DiscoveredKubeCluster {
+	return r.store.List()
+}
+
+// GetByKey returns the cluster stored under key, if any.
+func (r *Reflector) GetByKey(key string) (*DiscoveredKubeCluster, bool) {
+	return r.store.GetByKey(key)
+}
+
+// HasSynced reports whether the Reflector has completed at least one full
+// poll of its fetcher.
+func (r *Reflector) HasSynced() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hasSynced
+}
+
+// Run polls the fetcher on a ticker and dispatches deltas to the handler
+// until ctx is cancelled.
+func (r *Reflector) Run(ctx context.Context) {
+	go r.runProcessor(ctx)
+
+	ticker := time.NewTicker(r.waitTime)
+	defer ticker.Stop()
+	for {
+		if err := r.resync(ctx); err != nil {
+			log.Error("Failed to fetch EKS clusters: ", err)
+		}
+		select {
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+			r.fifo.close()
+			return
+		}
+	}
+}
+
+// resync polls the fetcher once, reconciles it against the store, and
+// pushes the resulting deltas onto the FIFO for runProcessor to dispatch.
+func (r *Reflector) resync(ctx context.Context) error {
+	clusters, err := r.fetcher.GetKubeClusters(ctx)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{}, len(clusters))
+	for _, cluster := range clusters {
+		key := cluster.Key()
+		seen[key] = struct{}{}
+
+		old, existed := r.store.GetByKey(key)
+		r.store.replace(key, cluster)
+		switch {
+		case !existed:
+			r.fifo.push(key, Delta{Type: Added, Cluster: cluster})
+		case !clustersEqual(old, cluster):
+			r.fifo.push(key, Delta{Type: Updated, Cluster: cluster, Old: old})
+		default:
+			r.fifo.push(key, Delta{Type: Sync, Cluster: cluster})
+		}
+	}
+
+	for _, old := range r.store.List() {
+		key := old.Key()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		r.store.delete(key)
+		r.fifo.push(key, Delta{Type: Deleted, Cluster: old})
+	}
+
+	r.mu.Lock()
+	r.hasSynced = true
+	r.mu.Unlock()
+	return nil
+}
+
+// runProcessor drains the FIFO, dispatching each delta to the handler in
+// arrival order, until ctx is cancelled and the FIFO is closed and drained.
+func (r *Reflector) runProcessor(ctx context.Context) {
+	for {
+		_, deltas, ok := r.fifo.pop()
+		if !ok {
+			return
+		}
+		for _, d := range deltas {
+			if r.handler == nil {
+				continue
+			}
+			switch d.Type {
+			case Added:
+				r.handler.OnAdd(d.Cluster)
+			case Updated:
+				r.handler.OnUpdate(d.Old, d.Cluster)
+			case Sync:
+				r.handler.OnUpdate(d.Cluster, d.Cluster)
+			case Deleted:
+				r.handler.OnDelete(d.Cluster)
+			}
+		}
+	}
+}
+
+// clustersEqual reports whether two discovered cluster descriptions are
+// equivalent for the fields the kube proxy cares about, so an unchanged
+// cluster reappearing in a poll produces a Sync delta instead of Updated.
+func clustersEqual(a, b *DiscoveredKubeCluster) bool {
+	return a.Status == b.Status &&
+		a.Endpoint == b.Endpoint &&
+		a.Version == b.Version &&
+		labelsEqual(a.Labels, b.Labels)
+}
+
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
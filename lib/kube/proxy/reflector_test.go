@@ -0,0 +1,182 @@
+/*
+Copyright 2022 Gravitational, Inc.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syntheticFetcher is a fetcher whose snapshots are set by the test, letting
+// it drive a Reflector through an arbitrary sequence of polls without
+// talking to any cloud API.
+type syntheticFetcher struct {
+	mu        sync.Mutex
+	snapshots [][]*DiscoveredKubeCluster
+	next      int
+}
+
+func (f *syntheticFetcher) GetKubeClusters(ctx context.Context) ([]*DiscoveredKubeCluster, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.next >= len(f.snapshots) {
+		// Hold the last snapshot steady once the test has run out of
+		// distinct polls to make.
+		return f.snapshots[len(f.snapshots)-1], nil
+	}
+	snapshot := f.snapshots[f.next]
+	f.next++
+	return snapshot, nil
+}
+
+// recordingHandler collects the deltas dispatched to it in arrival order.
+type recordingHandler struct {
+	mu      sync.Mutex
+	deltas  []Delta
+	updated chan struct{}
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{updated: make(chan struct{}, 1024)}
+}
+
+func (h *recordingHandler) OnAdd(cluster *DiscoveredKubeCluster) {
+	h.record(Delta{Type: Added, Cluster: cluster})
+}
+
+func (h *recordingHandler) OnUpdate(oldCluster, newCluster *DiscoveredKubeCluster) {
+	if oldCluster == newCluster {
+		h.record(Delta{Type: Sync, Cluster: newCluster})
+		return
+	}
+	h.record(Delta{Type: Updated, Cluster: newCluster})
+}
+
+func (h *recordingHandler) OnDelete(cluster *DiscoveredKubeCluster) {
+	h.record(Delta{Type: Deleted, Cluster: cluster})
+}
+
+func (h *recordingHandler) record(d Delta) {
+	h.mu.Lock()
+	h.deltas = append(h.deltas, d)
+	h.mu.Unlock()
+	h.updated <- struct{}{}
+}
+
+func (h *recordingHandler) snapshot() []Delta {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Delta{}, h.deltas...)
+}
+
+// waitForDeltas blocks until handler has recorded at least n deltas or the
+// test times out.
+func waitForDeltas(t *testing.T, handler *recordingHandler, n int) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		if len(handler.snapshot()) >= n {
+			return
+		}
+		select {
+		case <-handler.updated:
+		case <-deadline:
+			t.Fatalf("timed out waiting for %d deltas, got %d", n, len(handler.snapshot()))
+		}
+	}
+}
+
+func testCluster(name, status, endpoint, version string) *DiscoveredKubeCluster {
+	return &DiscoveredKubeCluster{
+		Cloud:     KubeCloudAWS,
+		Name:      name,
+		Region:    "us-east-1",
+		AccountID: "1234",
+		Status:    status,
+		Endpoint:  endpoint,
+		Version:   version,
+	}
+}
+
+func TestReflectorDeltaSequence(t *testing.T) {
+	a := testCluster("a", "ACTIVE", "a.example.com", "1.27")
+	aUpdated := testCluster("a", "ACTIVE", "a.example.com", "1.28")
+	b := testCluster("b", "ACTIVE", "b.example.com", "1.27")
+
+	fetcher := &syntheticFetcher{
+		snapshots: [][]*DiscoveredKubeCluster{
+			{a},           // poll 1: a is Added
+			{a, b},        // poll 2: a is Sync (unchanged), b is Added
+			{aUpdated, b}, // poll 3: a is Updated (version changed), b is Sync
+			{b},           // poll 4: a is Deleted, b is Sync
+		},
+	}
+	handler := newRecordingHandler()
+	reflector := NewReflector(fetcher, handler, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		reflector.fifo.close()
+	}()
+	go reflector.runProcessor(ctx)
+
+	// Drive exactly len(fetcher.snapshots) polls directly, rather than
+	// relying on Run's ticker, so the test isn't racing the clock for a
+	// precise delta count.
+	for range fetcher.snapshots {
+		require.NoError(t, reflector.resync(ctx))
+	}
+
+	// 1 + 2 + 2 + 2 = 7 deltas across the four polls above.
+	waitForDeltas(t, handler, 7)
+
+	deltas := handler.snapshot()
+	require.Len(t, deltas, 7)
+
+	require.Equal(t, Added, deltas[0].Type)
+	require.Equal(t, "a", deltas[0].Cluster.Name)
+
+	var sawBAdded, sawAUpdated, sawADeleted bool
+	for _, d := range deltas[1:] {
+		switch {
+		case d.Cluster.Name == "b" && d.Type == Added:
+			sawBAdded = true
+		case d.Cluster.Name == "a" && d.Type == Updated && d.Cluster.Version == "1.28":
+			sawAUpdated = true
+		case d.Cluster.Name == "a" && d.Type == Deleted:
+			sawADeleted = true
+		}
+	}
+	require.True(t, sawBAdded, "expected an Added delta for cluster b")
+	require.True(t, sawAUpdated, "expected an Updated delta for cluster a's version change")
+	require.True(t, sawADeleted, "expected a Deleted delta once cluster a drops out of the fetcher's snapshot")
+
+	require.Eventually(t, reflector.HasSynced, time.Second, 10*time.Millisecond)
+	_, ok := reflector.GetByKey(a.Key())
+	require.False(t, ok, "cluster a should have been removed from the store once deleted")
+	bStored, ok := reflector.GetByKey(b.Key())
+	require.True(t, ok)
+	require.Equal(t, "b", bStored.Name)
+}
+
+func TestReflectorHasSyncedBeforeFirstPoll(t *testing.T) {
+	fetcher := &syntheticFetcher{snapshots: [][]*DiscoveredKubeCluster{{testCluster("a", "ACTIVE", "a.example.com", "1.27")}}}
+	reflector := NewReflector(fetcher, newRecordingHandler(), time.Minute)
+	require.False(t, reflector.HasSynced())
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2022 Gravitational, Inc.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/gravitational/teleport/api/client/proto"
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/defaults"
+	"github.com/gravitational/trace"
+)
+
+// minReconcileInterval is the shortest time a given cluster key is
+// reconciled again after a previous reconcile, so a flapping cloud API
+// can't turn every poll into a backend write.
+const minReconcileInterval = 10 * time.Second
+
+// reporterQueueSize caps the number of pending reconcile events buffered
+// in front of Reporter.Run, mirroring the Watcher.Instances backpressure
+// pattern rather than blocking the Reflector that produced the event.
+const reporterQueueSize = 100
+
+// kubeServerPresence is the subset of services.Presence the Reporter needs
+// to reconcile discovered clusters into kube_server resources.
+type kubeServerPresence interface {
+	GetKubernetesServers(ctx context.Context) ([]types.KubernetesServerV3, error)
+	UpsertKubernetesServer(ctx context.Context, server types.KubernetesServerV3) error
+	DeleteKubernetesServer(ctx context.Context, hostID, name string) error
+}
+
+// kubeJoinTokenCreator mints the provision token a newly discovered cluster
+// uses to join, the Reporter's equivalent of lib/web's createJoinToken.
+type kubeJoinTokenCreator interface {
+	GenerateToken(ctx context.Context, req *proto.GenerateTokenRequest) (string, error)
+}
+
+// reconcileEvent is a single pending change to reconcile against Presence.
+type reconcileEvent struct {
+	kind    DeltaType
+	cluster *DiscoveredKubeCluster
+}
+
+// Reporter reconciles the clusters a Watcher discovers against Teleport's
+// kube_server resources: creating a KubernetesServerV3 for a newly
+// discovered cluster, patching label/version drift on update, and deleting
+// the server once its cloud resource disappears. The cluster's endpoint is
+// not recorded on the kube_server resource, so endpoint drift is not patched. It implements
+// ResourceEventHandler so it can be registered on a Watcher via Subscribe.
+type Reporter struct {
+	hostID   string
+	presence kubeServerPresence
+	tokens   kubeJoinTokenCreator
+
+	queue chan reconcileEvent
+
+	mu             sync.Mutex
+	lastReconciled map[string]time.Time
+	// joinTokens holds the most recently minted RoleKube join token per
+	// cluster name, so an agent installed right after discovery (e.g. via
+	// the Helm join-script mode) can join immediately.
+	joinTokens map[string]string
+}
+
+// NewReporter creates a Reporter that reconciles into presence, minting
+// join tokens for newly discovered clusters via tokens, and setting hostID
+// as the HostID of the kube_server resources it creates.
+func NewReporter(hostID string, presence kubeServerPresence, tokens kubeJoinTokenCreator) *Reporter {
+	return &Reporter{
+		hostID:         hostID,
+		presence:       presence,
+		tokens:         tokens,
+		queue:          make(chan reconcileEvent, reporterQueueSize),
+		lastReconciled: make(map[string]time.Time),
+		joinTokens:     make(map[string]string),
+	}
+}
+
+// JoinToken returns the most recently minted RoleKube join token for the
+// named cluster, if the Reporter has discovered it.
+func (r *Reporter) JoinToken(clusterName string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.joinTokens[clusterName]
+	return token, ok
+}
+
+// OnAdd implements ResourceEventHandler.
+func (r *Reporter) OnAdd(cluster *DiscoveredKubeCluster) { r.enqueue(Added, cluster) }
+
+// OnUpdate implements ResourceEventHandler.
+func (r *Reporter) OnUpdate(oldCluster, newCluster *DiscoveredKubeCluster) {
+	r.enqueue(Updated, newCluster)
+}
+
+// OnDelete implements ResourceEventHandler.
+func (r *Reporter) OnDelete(cluster *DiscoveredKubeCluster) { r.enqueue(Deleted, cluster) }
+
+func (r *Reporter) enqueue(kind DeltaType, cluster *DiscoveredKubeCluster) {
+	select {
+	case r.queue <- reconcileEvent{kind: kind, cluster: cluster}:
+	default:
+		log.Warningf("Kube cluster reporter queue full, dropping %s event for %s", kind, cluster.Key())
+	}
+}
+
+// Run drains the reconcile queue until ctx is cancelled. Sync should be
+// called once before Run, with the Watcher's initial HasSynced list, so a
+// reporter restarting after a transient auth failure reconfirms every
+// cluster instead of treating "not yet seen again" as "deleted".
+func (r *Reporter) Run(ctx context.Context) {
+	for {
+		select {
+		case ev := <-r.queue:
+			if err := r.reconcile(ctx, ev); err != nil {
+				log.Warningf("Failed to reconcile kube cluster %s: %v", ev.cluster.Key(), err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync reconciles every cluster in snapshot as a Sync event, re-asserting
+// the full known set against Presence. It's meant to run once a Watcher
+// reports HasSynced, so a reporter that just (re)started doesn't delete
+// servers for clusters it simply hasn't polled yet.
+func (r *Reporter) Sync(ctx context.Context, snapshot []*DiscoveredKubeCluster) error {
+	for _, cluster := range snapshot {
+		if err := r.reconcile(ctx, reconcileEvent{kind: Sync, cluster: cluster}); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// reconcile applies a single reconcile event to Presence, skipping repeat
+// non-delete events for the same cluster key within minReconcileInterval.
+func (r *Reporter) reconcile(ctx context.Context, ev reconcileEvent) error {
+	key := ev.cluster.Key()
+	if ev.kind != Deleted && !r.shouldReconcile(key) {
+		return nil
+	}
+
+	switch ev.kind {
+	case Added:
+		if err := r.mintJoinToken(ctx, ev.cluster.Name); err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(r.upsert(ctx, ev.cluster))
+	case Updated, Sync:
+		return trace.Wrap(r.upsert(ctx, ev.cluster))
+	case Deleted:
+		return trace.Wrap(r.presence.DeleteKubernetesServer(ctx, r.hostID, ev.cluster.Name))
+	default:
+		return trace.BadParameter("unsupported delta type %q", ev.kind)
+	}
+}
+
+// shouldReconcile reports whether key hasn't been reconciled within
+// minReconcileInterval, recording the attempt if so.
+func (r *Reporter) shouldReconcile(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.lastReconciled[key]; ok && time.Since(last) < minReconcileInterval {
+		return false
+	}
+	r.lastReconciled[key] = time.Now()
+	return true
+}
+
+// mintJoinToken creates a provision token scoped to RoleKube for a newly
+// discovered cluster, the same deterministic-purpose pattern lib/web's
+// createJoinToken uses for node enrollment, and caches it so JoinToken can
+// hand it to an install flow without a second round trip to auth.
+func (r *Reporter) mintJoinToken(ctx context.Context, clusterName string) error {
+	token, err := r.tokens.GenerateToken(ctx, &proto.GenerateTokenRequest{
+		Roles: types.SystemRoles{types.RoleKube},
+		TTL:   proto.Duration(defaults.NodeJoinTokenTTL),
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	r.mu.Lock()
+	r.joinTokens[clusterName] = token
+	r.mu.Unlock()
+	return nil
+}
+
+// upsert creates or patches the kube_server resource for cluster.
+func (r *Reporter) upsert(ctx context.Context, cluster *DiscoveredKubeCluster) error {
+	server, err := types.NewKubernetesServerV3(types.Metadata{
+		Name:   cluster.Name,
+		Labels: cluster.Labels,
+	}, types.KubernetesServerSpecV3{
+		HostID:  r.hostID,
+		Version: cluster.Version,
+		Cluster: &types.KubernetesClusterV3{
+			Metadata: types.Metadata{
+				Name:   cluster.Name,
+				Labels: cluster.Labels,
+			},
+		},
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	return trace.Wrap(r.presence.UpsertKubernetesServer(ctx, *server))
+}
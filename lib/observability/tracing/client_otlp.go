@@ -0,0 +1,129 @@
+// Copyright 2022 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/gravitational/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	otlp "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpGRPCClient uploads spans to a collector's OTLP/gRPC trace service.
+type otlpGRPCClient struct {
+	conn   *grpc.ClientConn
+	client coltracepb.TraceServiceClient
+}
+
+func newOTLPGRPCClient(cfg Config) (*otlpGRPCClient, error) {
+	if cfg.Endpoint == "" {
+		return nil, trace.BadParameter("otlp-grpc exporter requires an endpoint")
+	}
+
+	creds := credentials.NewTLS(nil)
+	var dialOpt grpc.DialOption = grpc.WithTransportCredentials(creds)
+	if cfg.Insecure {
+		dialOpt = grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+
+	conn, err := grpc.Dial(cfg.Endpoint, dialOpt)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &otlpGRPCClient{
+		conn:   conn,
+		client: coltracepb.NewTraceServiceClient(conn),
+	}, nil
+}
+
+func (c *otlpGRPCClient) UploadTraces(ctx context.Context, spans []*otlp.ResourceSpans) error {
+	_, err := c.client.Export(ctx, &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: spans,
+	})
+	return trace.Wrap(err)
+}
+
+func (c *otlpGRPCClient) Stop(ctx context.Context) error {
+	return trace.Wrap(c.conn.Close())
+}
+
+// otlpHTTPClient uploads spans to a collector's OTLP/HTTP trace endpoint,
+// encoding the request body as either protobuf or JSON per cfg.OTLPHTTPJSON.
+type otlpHTTPClient struct {
+	endpoint   string
+	httpClient *http.Client
+	asJSON     bool
+}
+
+func newOTLPHTTPClient(cfg Config) (*otlpHTTPClient, error) {
+	if cfg.Endpoint == "" {
+		return nil, trace.BadParameter("otlp-http exporter requires an endpoint")
+	}
+
+	return &otlpHTTPClient{
+		endpoint:   cfg.Endpoint,
+		httpClient: http.DefaultClient,
+		asJSON:     cfg.OTLPHTTPJSON,
+	}, nil
+}
+
+func (c *otlpHTTPClient) UploadTraces(ctx context.Context, spans []*otlp.ResourceSpans) error {
+	req := &coltracepb.ExportTraceServiceRequest{ResourceSpans: spans}
+
+	var body []byte
+	var contentType string
+	var err error
+	if c.asJSON {
+		body, err = protojson.Marshal(req)
+		contentType = "application/json"
+	} else {
+		body, err = proto.Marshal(req)
+		contentType = "application/x-protobuf"
+	}
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return trace.BadParameter("otlp collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *otlpHTTPClient) Stop(ctx context.Context) error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
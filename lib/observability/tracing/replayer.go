@@ -0,0 +1,260 @@
+// Copyright 2022 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	otlp "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var log = logrus.WithField("trace", "tracing")
+
+// cursorFileName is the sidecar file RotatingFileReplayer uses to remember
+// how far it has gotten through the rotation directory across restarts.
+const cursorFileName = ".cursor"
+
+// RotatingFileReplayer watches the directory written to by a
+// RotatingFileClient, forwarding each finalized file's spans to an upstream
+// Client and deleting the file once they're acknowledged. It turns the
+// file exporter's "write and forget" store into a durable queue for
+// collectors that are only intermittently reachable.
+type RotatingFileReplayer struct {
+	dir         string
+	upstream    Client
+	activeName  string
+	pollEvery   time.Duration
+	maxInFlight int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ReplayerConfig configures a RotatingFileReplayer.
+type ReplayerConfig struct {
+	// Dir is the rotation directory produced by a RotatingFileClient.
+	Dir string
+	// Upstream receives the spans read back from each finalized file.
+	Upstream Client
+	// ActiveFileName is the name of the file the writer is currently
+	// appending to; it is skipped so the replayer never reads a partial
+	// line out from under the writer.
+	ActiveFileName string
+	// PollEvery controls how often the replayer scans Dir for newly
+	// finalized files. Defaults to 5 seconds.
+	PollEvery time.Duration
+	// MaxInFlight bounds how many spans the replayer will hold in memory
+	// while waiting on Upstream.UploadTraces, providing backpressure when
+	// the downstream collector is slow or unreachable.
+	MaxInFlight int
+}
+
+// NewRotatingFileReplayer creates a RotatingFileReplayer. Call Start to begin
+// forwarding, and Stop to shut it down.
+func NewRotatingFileReplayer(cfg ReplayerConfig) (*RotatingFileReplayer, error) {
+	if cfg.Upstream == nil {
+		return nil, trace.BadParameter("replayer requires an upstream client")
+	}
+	if cfg.PollEvery <= 0 {
+		cfg.PollEvery = 5 * time.Second
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 1000
+	}
+
+	return &RotatingFileReplayer{
+		dir:         cfg.Dir,
+		upstream:    cfg.Upstream,
+		activeName:  cfg.ActiveFileName,
+		pollEvery:   cfg.PollEvery,
+		maxInFlight: cfg.MaxInFlight,
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// Start begins polling the rotation directory in the background.
+func (r *RotatingFileReplayer) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.pollEvery)
+		defer ticker.Stop()
+		for {
+			if err := r.replayOnce(ctx); err != nil {
+				log.WithError(err).Warn("Failed to replay buffered spans.")
+			}
+			select {
+			case <-ticker.C:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop cancels the background poll loop and waits for it to exit.
+func (r *RotatingFileReplayer) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	<-r.done
+}
+
+// fileEntry pairs a finalized file's name with its modification time, which
+// is the only ordering replayOnce can rely on: files are named
+// "<uuid>.trace" by RotatingFileClient, so their names carry no ordering
+// information at all.
+type fileEntry struct {
+	name    string
+	modTime time.Time
+}
+
+// replayOnce scans the rotation directory once, forwarding every finalized
+// file (i.e. every file that isn't the active write target) to the
+// upstream client, oldest first, and deleting it once acknowledged.
+func (r *RotatingFileReplayer) replayOnce(ctx context.Context) error {
+	dirEntries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	entries := make([]fileEntry, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		if entry.IsDir() || entry.Name() == cursorFileName || entry.Name() == r.activeName {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		entries = append(entries, fileEntry{name: entry.Name(), modTime: info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].modTime.Equal(entries[j].modTime) {
+			return entries[i].modTime.Before(entries[j].modTime)
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	resumeFrom, err := r.loadCursor()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	var inFlight int
+	for _, fe := range entries {
+		if fe.modTime.Before(resumeFrom) {
+			continue
+		}
+
+		spans, err := r.readFinalizedFile(filepath.Join(r.dir, fe.name))
+		if err != nil {
+			return trace.Wrap(err)
+		}
+
+		inFlight += len(spans)
+		if inFlight > r.maxInFlight {
+			// Stop for this pass; the remaining files will be picked up on
+			// the next tick once the collector has drained what we've
+			// already sent.
+			return nil
+		}
+
+		if len(spans) > 0 {
+			if err := r.upstream.UploadTraces(ctx, spans); err != nil {
+				return trace.Wrap(err)
+			}
+		}
+
+		if err := os.Remove(filepath.Join(r.dir, fe.name)); err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		if err := r.saveCursor(fe.modTime); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// readFinalizedFile reads every complete JSON line from name, tolerating a
+// trailing partial line (which can only happen if name is read while the
+// writer still holds it open, e.g. a race with rotation).
+func (r *RotatingFileReplayer) readFinalizedFile(name string) ([]*otlp.ResourceSpans, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+
+	var spans []*otlp.ResourceSpans
+	var badLine error
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if badLine != nil {
+			return nil, trace.Wrap(badLine)
+		}
+		var span otlp.ResourceSpans
+		if err := protojson.Unmarshal(line, &span); err != nil {
+			// A trailing partial line is expected if this file is somehow
+			// still being written to; anything else is a real error.
+			badLine = err
+			continue
+		}
+		spans = append(spans, &span)
+	}
+	return spans, trace.Wrap(scanner.Err())
+}
+
+func (r *RotatingFileReplayer) cursorPath() string {
+	return filepath.Join(r.dir, cursorFileName)
+}
+
+// loadCursor returns the modification time of the last file replayOnce
+// finished processing, or the zero time if there's no cursor yet.
+func (r *RotatingFileReplayer) loadCursor() (time.Time, error) {
+	data, err := os.ReadFile(r.cursorPath())
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, trace.ConvertSystemError(err)
+	}
+	cursor, err := time.Parse(time.RFC3339Nano, string(data))
+	if err != nil {
+		// A cursor file we can't parse is no better than having none; start
+		// over from the oldest file on disk rather than erroring forever.
+		return time.Time{}, nil
+	}
+	return cursor, nil
+}
+
+func (r *RotatingFileReplayer) saveCursor(modTime time.Time) error {
+	return trace.ConvertSystemError(os.WriteFile(r.cursorPath(), []byte(modTime.Format(time.RFC3339Nano)), 0600))
+}
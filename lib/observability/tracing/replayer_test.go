@@ -0,0 +1,74 @@
+// Copyright 2022 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	otlp "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// fakeUploadClient is a Client that records every batch it's given, for use
+// in tests that don't want to stand up a real collector.
+type fakeUploadClient struct {
+	uploaded [][]*otlp.ResourceSpans
+}
+
+func (f *fakeUploadClient) UploadTraces(ctx context.Context, spans []*otlp.ResourceSpans) error {
+	f.uploaded = append(f.uploaded, spans)
+	return nil
+}
+
+func (f *fakeUploadClient) Stop(ctx context.Context) error { return nil }
+
+func TestRotatingFileReplayer(t *testing.T) {
+	dir := t.TempDir()
+
+	client, err := NewRotatingFileClient(dir, 1)
+	require.NoError(t, err)
+
+	span := &otlp.ResourceSpans{}
+	require.NoError(t, client.UploadTraces(context.Background(), []*otlp.ResourceSpans{span}))
+	require.NoError(t, client.Stop(context.Background()))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	upstream := &fakeUploadClient{}
+	replayer, err := NewRotatingFileReplayer(ReplayerConfig{
+		Dir:      dir,
+		Upstream: upstream,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, replayer.replayOnce(context.Background()))
+
+	var total int
+	for _, batch := range upstream.uploaded {
+		total += len(batch)
+	}
+	require.Equal(t, 1, total)
+
+	// The finalized file should have been deleted after replay, but the
+	// cursor sidecar is left behind to checkpoint progress.
+	remaining, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Equal(t, cursorFileName, remaining[0].Name())
+}
@@ -0,0 +1,46 @@
+// Copyright 2022 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	otlp "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestRotatingFileClient_EnforceRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	client, err := NewRotatingFileClient(dir, 1, WithMaxFiles(2))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, client.UploadTraces(context.Background(), []*otlp.ResourceSpans{{}}))
+	}
+	require.NoError(t, client.Stop(context.Background()))
+
+	// The janitor runs on its own ticker, so directly invoke the
+	// enforcement pass instead of waiting on a background timer.
+	client.enforceRetention()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	// at most two sealed files, plus the active file, which is never deleted.
+	require.LessOrEqual(t, len(entries), 3)
+	require.Greater(t, client.DroppedFiles(), uint64(0))
+}
@@ -18,9 +18,14 @@ package server
 
 import (
 	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/cloudflare/cfssl/log"
@@ -31,77 +36,272 @@ import (
 	"github.com/gravitational/trace"
 )
 
+const (
+	// defaultMaxResultsPerPoll caps the number of instances a single
+	// fetcher poll returns, so a region with tens of thousands of
+	// instances can't balloon a single Instances batch.
+	defaultMaxResultsPerPoll = 1000
+
+	// instanceBufferSize is the capacity of Watcher.Instances. Once full,
+	// further batches are dropped rather than blocking the fetcher
+	// goroutine that produced them.
+	instanceBufferSize = 100
+
+	initialThrottleBackoff = time.Second
+	maxThrottleBackoff     = time.Minute
+)
+
+// CloudProvider discriminates which cloud a fetched Instances value came
+// from.
+type CloudProvider string
+
+const (
+	CloudProviderAWS   CloudProvider = "AWS"
+	CloudProviderAzure CloudProvider = "Azure"
+	CloudProviderGCP   CloudProvider = "GCP"
+)
+
+// EC2Instances is a batch of instances discovered in a single AWS region,
+// sharing the SSM document used to auto-enroll them.
 type EC2Instances struct {
 	Region    string
 	Document  string
 	Instances []*ec2.Instance
 }
 
+// AzureInstances is a batch of Azure VMs discovered in a single resource
+// group, enrolled via an Azure Run Command invocation of RunCommand.
+type AzureInstances struct {
+	SubscriptionID string
+	ResourceGroup  string
+	RunCommand     string
+	Instances      []*AzureInstance
+}
+
+// AzureInstance is the minimal set of fields the discovery/install path
+// needs about an Azure VM.
+type AzureInstance struct {
+	VMID string
+	Name string
+}
+
+// GCPInstances is a batch of GCP Compute instances discovered in a single
+// project/zone, enrolled via a `startup-script` metadata update.
+type GCPInstances struct {
+	ProjectID     string
+	Zone          string
+	StartupScript string
+	Instances     []*GCPInstance
+}
+
+// GCPInstance is the minimal set of fields the discovery/install path needs
+// about a GCP Compute instance.
+type GCPInstance struct {
+	ID   string
+	Name string
+}
+
+// Instances is a cloud-agnostic batch of discovered instances. Exactly one
+// of EC2/Azure/GCP is set, selected by Cloud.
+type Instances struct {
+	Cloud CloudProvider
+	EC2   *EC2Instances
+	Azure *AzureInstances
+	GCP   *GCPInstances
+}
+
+// InstanceFetcher polls a single cloud/region/project for instances matching
+// its configured matcher.
+type InstanceFetcher interface {
+	// GetInstances returns the current set of matching instances.
+	GetInstances(ctx context.Context) (*Instances, error)
+}
+
+// Watcher polls a heterogeneous set of InstanceFetchers - one per
+// cloud/region/project matcher - and fans their results into a single
+// buffered channel so the SSM-based auto-enrollment path can be reused
+// across clouds. Each fetcher is polled on its own goroutine and ticker, so
+// a slow or throttled region never stalls discovery for the others.
 type Watcher struct {
-	// Instances can be used to consume
-	Instances chan EC2Instances
+	// Instances can be used to consume discovered instance batches from
+	// every configured fetcher.
+	Instances chan Instances
 
-	fetchers []*ec2InstanceFetcher
-	waitTime time.Duration
-	ctx      context.Context
-	cancel   context.CancelFunc
+	fetchers         []InstanceFetcher
+	waitTime         time.Duration
+	droppedInstances uint64
+	ctx              context.Context
+	cancel           context.CancelFunc
 }
 
+// Run starts one polling goroutine per fetcher and blocks until Stop is
+// called.
 func (w *Watcher) Run() {
+	var wg sync.WaitGroup
+	for _, fetcher := range w.fetchers {
+		wg.Add(1)
+		go func(f InstanceFetcher) {
+			defer wg.Done()
+			w.pollFetcher(f)
+		}(fetcher)
+	}
+	wg.Wait()
+}
+
+// pollFetcher polls a single fetcher on its own ticker until w.ctx is
+// cancelled, backing off with jitter whenever the fetcher reports that the
+// cloud API is throttling requests.
+func (w *Watcher) pollFetcher(fetcher InstanceFetcher) {
 	ticker := time.NewTicker(w.waitTime)
+	defer ticker.Stop()
+
+	backoff := initialThrottleBackoff
 	for {
-		for _, fetcher := range w.fetchers {
-			inst, err := fetcher.GetEC2Instances(w.ctx)
-			if err != nil {
-				log.Error("Failed to fetch EC2 instances: ", err)
-				continue
+		inst, err := fetcher.GetInstances(w.ctx)
+		switch {
+		case err != nil && isThrottleError(err):
+			log.Warningf("Cloud API throttled instance discovery, backing off: %v", err)
+			if !w.sleep(jitter(backoff)) {
+				return
 			}
-			w.Instances <- *inst
+			backoff *= 2
+			if backoff > maxThrottleBackoff {
+				backoff = maxThrottleBackoff
+			}
+			continue
+		case err != nil:
+			log.Error("Failed to fetch instances: ", err)
+		default:
+			backoff = initialThrottleBackoff
+			w.send(*inst)
 		}
+
 		select {
 		case <-ticker.C:
-			continue
 		case <-w.ctx.Done():
 			return
 		}
 	}
 }
 
+// send delivers inst to Instances without blocking. If the channel is full,
+// the batch is dropped and counted so consumers that fall behind produce
+// observable pressure instead of stalling every fetcher goroutine.
+func (w *Watcher) send(inst Instances) {
+	select {
+	case w.Instances <- inst:
+	default:
+		atomic.AddUint64(&w.droppedInstances, 1)
+	}
+}
+
+// DroppedInstances returns the number of instance batches discarded because
+// Instances was full, so operators can alarm on a consumer falling behind.
+func (w *Watcher) DroppedInstances() uint64 {
+	return atomic.LoadUint64(&w.droppedInstances)
+}
+
+// sleep blocks for d or until w.ctx is cancelled, returning false in the
+// latter case so callers can stop retrying.
+func (w *Watcher) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// jitter returns a random duration in [d/2, d), so that many fetchers
+// throttled at once don't retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// isThrottleError reports whether err is an AWS API throttling response,
+// which should be backed off rather than retried on the next regular tick.
+func isThrottleError(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	switch awsErr.Code() {
+	case "RequestLimitExceeded", "Throttling", "ThrottlingException":
+		return true
+	default:
+		return false
+	}
+}
+
 func (w *Watcher) Stop() {
 	w.cancel()
 }
 
-func NewCloudServerWatcher(ctx context.Context, matchers []services.AWSMatcher, clients cloud.Clients) (*Watcher, error) {
+// NewCloudServerWatcher creates a Watcher with one InstanceFetcher per
+// (cloud, region/project) combination described by the supplied matchers.
+func NewCloudServerWatcher(ctx context.Context, matchers Matchers, clients cloud.Clients) (*Watcher, error) {
 	cancelCtx, cancelFn := context.WithCancel(ctx)
 	watcher := Watcher{
-		fetchers:  []*ec2InstanceFetcher{},
+		fetchers:  []InstanceFetcher{},
 		ctx:       cancelCtx,
 		cancel:    cancelFn,
 		waitTime:  time.Minute,
-		Instances: make(chan EC2Instances),
+		Instances: make(chan Instances, instanceBufferSize),
 	}
-	for _, matcher := range matchers {
+
+	for _, matcher := range matchers.AWS {
 		for _, region := range matcher.Regions {
 			cl, err := clients.GetAWSEC2Client(region)
 			if err != nil {
 				return nil, trace.Wrap(err)
 			}
-			fetcher :=
-				newEc2InstanceFetcher(matcher, region, matcher.SSMDocument, cl, matcher.Tags)
-			if err != nil {
-				return nil, trace.Wrap(err)
-			}
+			fetcher := newEc2InstanceFetcher(matcher, region, matcher.SSMDocument, cl, matcher.Tags)
 			watcher.fetchers = append(watcher.fetchers, fetcher)
 		}
 	}
+
+	// Azure and GCP instance discovery require SDK clients this build
+	// doesn't vendor (there's no Azure/GCP equivalent of cloud.Clients'
+	// GetAWSEC2Client here). Rather than register a fetcher whose every
+	// poll fails with NotImplemented - which looks, from the config and
+	// logs, like a region with nothing to enroll - fail the watcher
+	// construction outright so a misconfigured discovery service can't
+	// silently believe it's covering clouds it isn't.
+	for _, matcher := range matchers.Azure {
+		if len(matcher.Subscriptions) > 0 {
+			return nil, trace.NotImplemented("Azure VM discovery is not supported by this build; remove azure matchers from the discovery config")
+		}
+	}
+	for _, matcher := range matchers.GCP {
+		if len(matcher.ProjectIDs) > 0 {
+			return nil, trace.NotImplemented("GCP Compute instance discovery is not supported by this build; remove gcp matchers from the discovery config")
+		}
+	}
+
 	return &watcher, nil
 }
 
+// Matchers bundles the per-cloud matcher configs NewCloudServerWatcher fans
+// out over, mirroring how discovery configs group AWS/Azure/GCP sections
+// today.
+type Matchers struct {
+	AWS   []services.AWSMatcher
+	Azure []services.AzureMatcher
+	GCP   []services.GCPMatcher
+}
+
 type ec2InstanceFetcher struct {
-	Filters  []*ec2.Filter
-	EC2      ec2iface.EC2API
-	Region   string
-	Document string
+	Filters    []*ec2.Filter
+	EC2        ec2iface.EC2API
+	Region     string
+	Document   string
+	MaxResults int
 }
 
 func newEc2InstanceFetcher(matcher services.AWSMatcher, region, document string,
@@ -118,22 +318,41 @@ func newEc2InstanceFetcher(matcher services.AWSMatcher, region, document string,
 		})
 	}
 	fetcherConfig := ec2InstanceFetcher{
-		EC2:      ec2Client,
-		Filters:  tagFilters,
-		Region:   region,
-		Document: document,
+		EC2:        ec2Client,
+		Filters:    tagFilters,
+		Region:     region,
+		Document:   document,
+		MaxResults: defaultMaxResultsPerPoll,
 	}
 	return &fetcherConfig
 }
 
+// GetInstances implements InstanceFetcher.
+func (f *ec2InstanceFetcher) GetInstances(ctx context.Context) (*Instances, error) {
+	inst, err := f.GetEC2Instances(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Instances{Cloud: CloudProviderAWS, EC2: inst}, nil
+}
+
 func (f *ec2InstanceFetcher) GetEC2Instances(ctx context.Context) (*EC2Instances, error) {
 	var instances []*ec2.Instance
+	maxResults := f.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultMaxResultsPerPoll
+	}
+
 	err := f.EC2.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
 		Filters: f.Filters,
 	},
-		func(dio *ec2.DescribeInstancesOutput, b bool) bool {
+		func(dio *ec2.DescribeInstancesOutput, lastPage bool) bool {
 			for _, res := range dio.Reservations {
 				instances = append(instances, res.Instances...)
+				if len(instances) >= maxResults {
+					instances = instances[:maxResults]
+					return false
+				}
 			}
 			return true
 		})
@@ -148,3 +367,4 @@ func (f *ec2InstanceFetcher) GetEC2Instances(ctx context.Context) (*EC2Instances
 		Instances: instances,
 	}, nil
 }
+
@@ -17,106 +17,586 @@ limitations under the License.
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/cloudflare/cfssl/log"
 	"github.com/gravitational/teleport/api/types/events"
 	libevent "github.com/gravitational/teleport/lib/events"
 	"github.com/gravitational/trace"
 )
 
+const (
+	// initialPollBackoff is the wait before the first repoll of an
+	// in-progress command.
+	initialPollBackoff = time.Second
+	// maxPollBackoff caps the backoff between repolls of a single
+	// long-running command.
+	maxPollBackoff = 30 * time.Second
+	// maxConcurrentPolls bounds how many instances are polled at once, so a
+	// batch of hundreds of instances doesn't open hundreds of simultaneous
+	// GetCommandInvocation calls.
+	maxConcurrentPolls = 10
+	// defaultProgressOutputCap is the default number of trailing bytes of
+	// command plugin output kept per EC2DiscoveryScriptProgress event.
+	defaultProgressOutputCap = 4000
+	// maxDiagnosticsPollAttempts bounds how long collectFailureDiagnostics
+	// waits for the diagnostics command to finish, so a stuck SSM agent on
+	// the failed instance can't hold up the rest of the batch indefinitely.
+	maxDiagnosticsPollAttempts = 10
+	// diagnosticsOutputCap is the number of trailing bytes of diagnostics
+	// output kept, matching defaultProgressOutputCap's rationale of bounding
+	// what ends up in the audit event.
+	diagnosticsOutputCap = 4000
+	// defaultSuccessTTL is how long a successful install is trusted before
+	// the instance is attempted again.
+	defaultSuccessTTL = 24 * time.Hour
+	// defaultFailureBackoff is the minimum wait before retrying an
+	// instance's first failed attempt; it doubles per AttemptCount up to
+	// maxFailureBackoff.
+	defaultFailureBackoff = time.Minute
+	maxFailureBackoff     = time.Hour
+	// installStateParameterPrefix namespaces the SSM Parameter Store
+	// parameters SSMParameterStateStore reads and writes.
+	installStateParameterPrefix = "/teleport/discovery/install/"
+)
+
+// failureDiagnosticsScript runs a small set of commands useful for
+// post-mortem debugging an instance whose install document failed,
+// mirroring what an operator would run over SSH if the instance were
+// reachable that way.
+const failureDiagnosticsScript = "journalctl -u amazon-ssm-agent; cat /var/log/amazon/ssm/errors.log; systemctl status teleport"
+
+// InstallState is the persisted record of an instance's most recent install
+// attempt, keyed by instance ID. It lets a restarted discovery agent resume
+// polling an in-flight command instead of re-running the install document,
+// skip an instance that already succeeded recently, and back off one that
+// keeps failing.
+type InstallState struct {
+	CommandID    string    `json:"command_id"`
+	StartedAt    time.Time `json:"started_at"`
+	CompletedAt  time.Time `json:"completed_at"`
+	Status       string    `json:"status"`
+	ExitCode     int64     `json:"exit_code"`
+	AttemptCount int       `json:"attempt_count"`
+}
+
+// StateStore persists InstallState per instance so Installation can resume
+// in-flight commands and skip recently-succeeded or recently-failed
+// instances across restarts. Get's second return value is false when no
+// state exists for instanceID.
+type StateStore interface {
+	Get(ctx context.Context, instanceID string) (InstallState, bool, error)
+	Put(ctx context.Context, instanceID string, state InstallState) error
+	Delete(ctx context.Context, instanceID string) error
+}
+
+// SSMParameterStateStore implements StateStore using AWS SSM Parameter
+// Store, the default StateStore so a discovery agent doesn't need a
+// separate datastore to resume installs across restarts.
+type SSMParameterStateStore struct {
+	SSM ssmiface.SSMAPI
+}
+
+func NewSSMParameterStateStore(client ssmiface.SSMAPI) *SSMParameterStateStore {
+	return &SSMParameterStateStore{SSM: client}
+}
+
+// Get implements StateStore.
+func (s *SSMParameterStateStore) Get(ctx context.Context, instanceID string) (InstallState, bool, error) {
+	out, err := s.SSM.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name: aws.String(installStateParameterPrefix + instanceID),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == ssm.ErrCodeParameterNotFound {
+			return InstallState{}, false, nil
+		}
+		return InstallState{}, false, trace.Wrap(err)
+	}
+
+	var state InstallState
+	if err := json.Unmarshal([]byte(aws.StringValue(out.Parameter.Value)), &state); err != nil {
+		return InstallState{}, false, trace.Wrap(err)
+	}
+	return state, true, nil
+}
+
+// Put implements StateStore.
+func (s *SSMParameterStateStore) Put(ctx context.Context, instanceID string, state InstallState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	_, err = s.SSM.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(installStateParameterPrefix + instanceID),
+		Value:     aws.String(string(value)),
+		Type:      aws.String(ssm.ParameterTypeString),
+		Overwrite: aws.Bool(true),
+	})
+	return trace.Wrap(err)
+}
+
+// Delete implements StateStore.
+func (s *SSMParameterStateStore) Delete(ctx context.Context, instanceID string) error {
+	_, err := s.SSM.DeleteParameterWithContext(ctx, &ssm.DeleteParameterInput{
+		Name: aws.String(installStateParameterPrefix + instanceID),
+	})
+	return trace.Wrap(err)
+}
+
+// Installer runs a document/script that installs the Teleport agent on a
+// batch of already-discovered instances, using whichever remote-execution
+// mechanism its cloud provides, and reports the outcome as the same
+// DiscoveryScript* event regardless of which cloud ran it.
+type Installer interface {
+	Install(ctx context.Context, document string, params map[string]string) ([]*events.EC2DiscoveryScriptRun, error)
+}
+
+// Installation installs the Teleport agent on EC2 instances via SSM
+// RunCommand, satisfying Installer.
 type Installation struct {
 	instances []*string
 	SSM       ssmiface.SSMAPI
-	rechecker time.Ticker
-	params    map[string][]*string
+
+	// Emitter streams EC2DiscoveryScriptProgress events while a command is
+	// in flight. Only consulted when StreamProgress is set.
+	Emitter events.Emitter
+	// StreamProgress gates emitting live progress events through Emitter,
+	// off by default so installs that don't configure an Emitter don't pay
+	// for the extra ListCommandInvocations polling.
+	StreamProgress bool
+	// ProgressOutputCap is the number of trailing bytes of command plugin
+	// output kept per progress event. Defaults to defaultProgressOutputCap
+	// when left zero.
+	ProgressOutputCap int
+
+	// CollectFailureDiagnostics runs a best-effort SSM command on a failed
+	// instance to capture diagnostics and attaches them to its terminal
+	// event (see collectFailureDiagnostics for why this is a SendCommand
+	// invocation rather than an interactive Session Manager session). Off
+	// by default since it requires the ssm:SendCommand and
+	// ssm:GetCommandInvocation IAM permissions in addition to what install
+	// already needs.
+	CollectFailureDiagnostics bool
+
+	// StateStore persists install attempts per instance so a restarted
+	// Installation can resume polling an in-flight command, skip an
+	// instance that succeeded within SuccessTTL, and back off one that's
+	// repeatedly failing. Installs run without resume/skip/backoff when
+	// left nil.
+	StateStore StateStore
+	// SuccessTTL is how long a successful install is trusted before the
+	// instance is attempted again. Defaults to defaultSuccessTTL when zero.
+	SuccessTTL time.Duration
+	// FailureBackoff is the minimum wait before retrying an instance whose
+	// last attempt failed, doubled per AttemptCount. Defaults to
+	// defaultFailureBackoff when zero.
+	FailureBackoff time.Duration
 }
 
-func NewInstallation(client ssmiface.SSMAPI, instances []*ec2.Instance, params map[string]string) *Installation {
+func NewInstallation(client ssmiface.SSMAPI, instances []*ec2.Instance, emitter events.Emitter, streamProgress, collectFailureDiagnostics bool, stateStore StateStore) *Installation {
 	var ids []*string
 
 	for _, inst := range instances {
 		ids = append(ids, inst.InstanceId)
 	}
 
-	ssmParams := make(map[string][]*string)
+	return &Installation{
+		instances:                 ids,
+		SSM:                       client,
+		Emitter:                   emitter,
+		StreamProgress:            streamProgress,
+		ProgressOutputCap:         defaultProgressOutputCap,
+		CollectFailureDiagnostics: collectFailureDiagnostics,
+		StateStore:                stateStore,
+	}
+}
+
+// instancePlan is a single instance's resolved plan for one Install call:
+// either resume an in-flight command (resumeCommandID set from prior
+// state) or ride along on the fresh SendCommand about to be issued.
+type instancePlan struct {
+	id              *string
+	resumeCommandID *string
+	attemptCount    int
+	startedAt       time.Time
+}
 
+var ErrCommandInProgress = errors.New("command in progress")
+
+// Install implements Installer. It polls every instance's command
+// invocation concurrently so one slow or throttled instance can't stall the
+// rest of the batch, and always returns whatever results were gathered by
+// the time ctx is cancelled rather than discarding them. When StateStore is
+// set, it skips instances that succeeded recently, resumes instances with
+// an in-flight command from a previous Installation instead of re-sending
+// the document, and backs off instances that keep failing.
+func (i *Installation) Install(ctx context.Context, document string, params map[string]string) ([]*events.EC2DiscoveryScriptRun, error) {
+	ssmParams := make(map[string][]*string, len(params))
 	for key, val := range params {
 		ssmParams[key] = []*string{aws.String(val)}
 	}
 
-	return &Installation{
-		instances: ids,
-		SSM:       client,
-		rechecker: *time.NewTicker(time.Second * 30),
-		params:    ssmParams,
+	fresh, resume := i.planInstances(ctx)
+
+	if len(fresh) > 0 {
+		ids := make([]*string, len(fresh))
+		for idx, plan := range fresh {
+			ids[idx] = plan.id
+		}
+
+		output, err := i.SSM.SendCommandWithContext(ctx, &ssm.SendCommandInput{
+			DocumentName: aws.String(document),
+			InstanceIds:  ids,
+			Parameters:   ssmParams,
+		})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+
+		now := time.Now()
+		for idx := range fresh {
+			fresh[idx].resumeCommandID = output.Command.CommandId
+			fresh[idx].startedAt = now
+			i.saveState(ctx, fresh[idx], ssm.CommandStatusInProgress, 0)
+		}
 	}
+
+	return i.pollPlans(ctx, append(fresh, resume...)), nil
 }
 
-var ErrCommandInProgress = errors.New("command in progress")
+// planInstances partitions i.instances into those that need a fresh
+// SendCommand (fresh) and those that should resume polling an in-flight
+// command from a previous Installation (resume), skipping instances that
+// succeeded within SuccessTTL or failed too recently to retry yet.
+func (i *Installation) planInstances(ctx context.Context) (fresh, resume []instancePlan) {
+	for _, inst := range i.instances {
+		if i.StateStore == nil {
+			fresh = append(fresh, instancePlan{id: inst, attemptCount: 1})
+			continue
+		}
+
+		state, ok, err := i.StateStore.Get(ctx, aws.StringValue(inst))
+		if err != nil {
+			log.Warningf("Failed to load install state for instance %s, treating as fresh: %v", aws.StringValue(inst), err)
+			fresh = append(fresh, instancePlan{id: inst, attemptCount: 1})
+			continue
+		}
+		if !ok {
+			fresh = append(fresh, instancePlan{id: inst, attemptCount: 1})
+			continue
+		}
+
+		switch state.Status {
+		case ssm.CommandStatusSuccess:
+			if time.Since(state.CompletedAt) < i.successTTL() {
+				continue
+			}
+			fresh = append(fresh, instancePlan{id: inst, attemptCount: state.AttemptCount + 1})
+		case ssm.CommandStatusInProgress:
+			resume = append(resume, instancePlan{
+				id:              inst,
+				resumeCommandID: aws.String(state.CommandID),
+				attemptCount:    state.AttemptCount,
+				startedAt:       state.StartedAt,
+			})
+		default:
+			if time.Since(state.StartedAt) < i.failureBackoff(state.AttemptCount) {
+				continue
+			}
+			fresh = append(fresh, instancePlan{id: inst, attemptCount: state.AttemptCount + 1})
+		}
+	}
+	return fresh, resume
+}
+
+// pollPlans polls every plan's command status concurrently, bounded by
+// maxConcurrentPolls, persists each terminal result to StateStore, and
+// collects whatever results converge before ctx is cancelled.
+func (i *Installation) pollPlans(ctx context.Context, plans []instancePlan) []*events.EC2DiscoveryScriptRun {
+	results := make(chan *events.EC2DiscoveryScriptRun, len(plans))
+	sem := make(chan struct{}, maxConcurrentPolls)
+
+	var wg sync.WaitGroup
+	for _, plan := range plans {
+		wg.Add(1)
+		go func(plan instancePlan) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			event := i.pollInstance(ctx, plan.resumeCommandID, plan.id)
+			if event == nil {
+				return
+			}
+			i.saveState(ctx, plan, event.Status, event.ExitCode)
+			results <- event
+		}(plan)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
-func (i *Installation) checkCommands(commandID *string) ([]*events.EC2DiscoveryScriptRun, error) {
 	var resultCmds []*events.EC2DiscoveryScriptRun
-	for _, inst := range i.instances {
-		cmdOut, err := i.SSM.GetCommandInvocation(&ssm.GetCommandInvocationInput{
+	for event := range results {
+		resultCmds = append(resultCmds, event)
+	}
+	return resultCmds
+}
+
+// saveState persists plan's current status to StateStore, a no-op when
+// StateStore is nil.
+func (i *Installation) saveState(ctx context.Context, plan instancePlan, status string, exitCode int64) {
+	if i.StateStore == nil {
+		return
+	}
+
+	state := InstallState{
+		CommandID:    aws.StringValue(plan.resumeCommandID),
+		StartedAt:    plan.startedAt,
+		Status:       status,
+		ExitCode:     exitCode,
+		AttemptCount: plan.attemptCount,
+	}
+	if status != ssm.CommandStatusInProgress {
+		state.CompletedAt = time.Now()
+	}
+
+	if err := i.StateStore.Put(ctx, aws.StringValue(plan.id), state); err != nil {
+		log.Warningf("Failed to persist install state for instance %s: %v", aws.StringValue(plan.id), err)
+	}
+}
+
+// successTTL returns the configured SuccessTTL, falling back to
+// defaultSuccessTTL when it's left unset.
+func (i *Installation) successTTL() time.Duration {
+	if i.SuccessTTL <= 0 {
+		return defaultSuccessTTL
+	}
+	return i.SuccessTTL
+}
+
+// failureBackoff returns the minimum wait before retrying an instance on
+// its (attempt+1)'th attempt, doubling FailureBackoff per prior attempt up
+// to maxFailureBackoff.
+func (i *Installation) failureBackoff(attempt int) time.Duration {
+	backoff := i.FailureBackoff
+	if backoff <= 0 {
+		backoff = defaultFailureBackoff
+	}
+	for n := 0; n < attempt; n++ {
+		backoff *= 2
+		if backoff >= maxFailureBackoff {
+			return maxFailureBackoff
+		}
+	}
+	return backoff
+}
+
+// pollInstance repolls a single instance's command invocation, backing off
+// with jitter while it's still CommandStatusInProgress, until it reaches a
+// terminal status or ctx is cancelled. It returns nil on cancellation or a
+// polling error so that instance is simply absent from the batch's results
+// rather than discarding every other instance's already-gathered result,
+// the bug this replaces the old checkCommands/ErrCommandInProgress loop to
+// fix.
+func (i *Installation) pollInstance(ctx context.Context, commandID, instanceID *string) *events.EC2DiscoveryScriptRun {
+	start := time.Now()
+	backoff := initialPollBackoff
+	for {
+		cmdOut, err := i.SSM.GetCommandInvocationWithContext(ctx, &ssm.GetCommandInvocationInput{
 			CommandId:  commandID,
-			InstanceId: inst,
+			InstanceId: instanceID,
 		})
 		if err != nil {
-			return nil, trace.Wrap(err)
+			log.Warningf("Failed to poll SSM command invocation for instance %s: %v", aws.StringValue(instanceID), err)
+			return nil
 		}
+
 		status := aws.StringValue(cmdOut.Status)
 		if status == ssm.CommandStatusInProgress {
-			return nil, trace.Wrap(ErrCommandInProgress)
+			i.emitProgress(ctx, commandID, instanceID, time.Since(start))
+			select {
+			case <-time.After(jitter(backoff)):
+			case <-ctx.Done():
+				return nil
+			}
+			backoff *= 2
+			if backoff > maxPollBackoff {
+				backoff = maxPollBackoff
+			}
+			continue
 		}
 
-		var code string
+		var code, diagnostics string
 		if status == ssm.CommandStatusFailed {
 			code = libevent.DiscoveryScriptEC2FailCode
+			if i.CollectFailureDiagnostics {
+				diagnostics = i.collectFailureDiagnostics(ctx, instanceID)
+			}
 		} else {
 			code = libevent.DiscoveryScriptEC2SuccessCode
 		}
 
-		event := events.EC2DiscoveryScriptRun{
+		return &events.EC2DiscoveryScriptRun{
 			Metadata: events.Metadata{
 				Type: libevent.EC2DiscoveryInstallScriptEvent,
 				Code: code,
 			},
-			CommandID:  aws.StringValue(commandID),
-			InstanceID: aws.StringValue(inst),
-			ExitCode:   aws.Int64Value(cmdOut.ResponseCode),
-			Status:     status,
+			CommandID:   aws.StringValue(commandID),
+			InstanceID:  aws.StringValue(instanceID),
+			ExitCode:    aws.Int64Value(cmdOut.ResponseCode),
+			Status:      status,
+			Diagnostics: diagnostics,
 		}
-
-		resultCmds = append(resultCmds, &event)
 	}
-	return resultCmds, nil
 }
 
-func (i *Installation) DoInstall(document string) ([]*events.EC2DiscoveryScriptRun, error) {
-	output, err := i.SSM.SendCommand(&ssm.SendCommandInput{
-		DocumentName: aws.String(document),
-		InstanceIds:  i.instances,
-		Parameters:   i.params,
+// collectFailureDiagnostics runs failureDiagnosticsScript on instanceID via
+// the AWS-RunShellScript document and returns its captured stdout/stderr,
+// tailed to diagnosticsOutputCap bytes. It polls GetCommandInvocation
+// directly, the same primitive pollInstance uses for the install command
+// itself, rather than opening an interactive Session Manager session, since
+// that would additionally require speaking the session-manager-plugin data
+// channel protocol just to recover output GetCommandInvocation already
+// returns. Only called when CollectFailureDiagnostics is set.
+func (i *Installation) collectFailureDiagnostics(ctx context.Context, instanceID *string) string {
+	output, err := i.SSM.SendCommandWithContext(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String("AWS-RunShellScript"),
+		InstanceIds:  []*string{instanceID},
+		Parameters: map[string][]*string{
+			"commands": {aws.String(failureDiagnosticsScript)},
+		},
 	})
 	if err != nil {
-		return nil, trace.Wrap(err)
+		log.Warningf("Failed to start SSM diagnostics command for instance %s: %v", aws.StringValue(instanceID), err)
+		return ""
 	}
-
 	commandID := output.Command.CommandId
-	for {
-		<-i.rechecker.C
-		result, err := i.checkCommands(commandID)
+
+	backoff := initialPollBackoff
+	for attempt := 0; attempt < maxDiagnosticsPollAttempts; attempt++ {
+		cmdOut, err := i.SSM.GetCommandInvocationWithContext(ctx, &ssm.GetCommandInvocationInput{
+			CommandId:  commandID,
+			InstanceId: instanceID,
+		})
 		if err != nil {
-			if errors.Is(err, ErrCommandInProgress) {
-				continue
+			log.Warningf("Failed to poll SSM diagnostics command for instance %s: %v", aws.StringValue(instanceID), err)
+			return ""
+		}
+
+		if aws.StringValue(cmdOut.Status) == ssm.CommandStatusInProgress {
+			select {
+			case <-time.After(jitter(backoff)):
+			case <-ctx.Done():
+				return ""
 			}
-			return result, err
+			backoff *= 2
+			if backoff > maxPollBackoff {
+				backoff = maxPollBackoff
+			}
+			continue
 		}
-		return result, nil
+
+		diagnostics := aws.StringValue(cmdOut.StandardOutputContent) + aws.StringValue(cmdOut.StandardErrorContent)
+		if len(diagnostics) > diagnosticsOutputCap {
+			diagnostics = diagnostics[len(diagnostics)-diagnosticsOutputCap:]
+		}
+		return diagnostics
+	}
+
+	log.Warningf("Timed out waiting for SSM diagnostics command on instance %s", aws.StringValue(instanceID))
+	return ""
+}
+
+// emitProgress streams an EC2DiscoveryScriptProgress event carrying the
+// command's current step and a truncated tail of its output, so operators
+// watching the audit log get the same live feedback an interactive SSM
+// session would show. Emit failures are logged and otherwise ignored; they
+// must never abort the poll loop that produces the terminal result.
+func (i *Installation) emitProgress(ctx context.Context, commandID, instanceID *string, elapsed time.Duration) {
+	if i.Emitter == nil || !i.StreamProgress {
+		return
+	}
+
+	invocation, err := i.SSM.ListCommandInvocationsWithContext(ctx, &ssm.ListCommandInvocationsInput{
+		CommandId:  commandID,
+		InstanceId: instanceID,
+		Details:    aws.Bool(true),
+	})
+	if err != nil {
+		log.Warningf("Failed to list SSM command invocations for instance %s: %v", aws.StringValue(instanceID), err)
+		return
+	}
+
+	var stepName, output string
+	for _, inv := range invocation.CommandInvocations {
+		for _, plugin := range inv.CommandPlugins {
+			stepName = aws.StringValue(plugin.Name)
+			output = aws.StringValue(plugin.Output)
+		}
+	}
+
+	if cap := i.progressOutputCap(); len(output) > cap {
+		output = output[len(output)-cap:]
+	}
+
+	err = i.Emitter.EmitAuditEvent(ctx, &events.EC2DiscoveryScriptProgress{
+		Metadata: events.Metadata{
+			Type: libevent.EC2DiscoveryInstallScriptEvent,
+			Code: libevent.DiscoveryScriptEC2ProgressCode,
+		},
+		CommandID:  aws.StringValue(commandID),
+		InstanceID: aws.StringValue(instanceID),
+		StepName:   stepName,
+		Output:     output,
+		Elapsed:    elapsed.String(),
+	})
+	if err != nil {
+		log.Warningf("Failed to emit install progress event for instance %s: %v", aws.StringValue(instanceID), err)
+	}
+}
+
+// progressOutputCap returns the configured ProgressOutputCap, falling back
+// to defaultProgressOutputCap when it's left unset.
+func (i *Installation) progressOutputCap() int {
+	if i.ProgressOutputCap <= 0 {
+		return defaultProgressOutputCap
+	}
+	return i.ProgressOutputCap
+}
+
+// NewInstaller selects the Installer matching inst.Cloud, so the discovery
+// service can dispatch each batch of newly found instances to the right
+// cloud's remote-execution mechanism without a type switch at every call
+// site.
+//
+// Azure and GCP have no Installer implementation in this build: installing
+// via Azure Run Command or a GCP OS Config patch job needs their respective
+// compute SDK clients, which aren't vendored here, and NewCloudServerWatcher
+// already refuses to construct a watcher with Azure/GCP matchers configured
+// - so inst.Cloud being one of them would mean a caller built an Instances
+// batch by hand rather than one of those never reaching here.
+func NewInstaller(inst Instances, ssmClient ssmiface.SSMAPI, emitter events.Emitter, streamProgress, collectFailureDiagnostics bool, stateStore StateStore) (Installer, error) {
+	switch inst.Cloud {
+	case CloudProviderAWS:
+		return NewInstallation(ssmClient, inst.EC2.Instances, emitter, streamProgress, collectFailureDiagnostics, stateStore), nil
+	case CloudProviderAzure:
+		return nil, trace.NotImplemented("Azure Run Command installs are not supported by this build")
+	case CloudProviderGCP:
+		return nil, trace.NotImplemented("GCP OS Config installs are not supported by this build")
+	default:
+		return nil, trace.BadParameter("unsupported cloud provider %q", inst.Cloud)
 	}
 }
@@ -64,6 +64,11 @@ type scriptSettings struct {
 	appURI         string
 	joinMethod     string
 	nodeLabels     string
+
+	helmInstallMode bool
+	kubeClusterName string
+	releaseName     string
+	namespace       string
 }
 
 func (h *Handler) createTokenHandle(w http.ResponseWriter, r *http.Request, params httprouter.Params, ctx *SessionContext) (interface{}, error) {
@@ -109,6 +114,66 @@ func (h *Handler) createTokenHandle(w http.ResponseWriter, r *http.Request, para
 
 		// IAM tokens should 'never' expire
 		expires = time.Now().UTC().AddDate(1000, 0, 0)
+	case types.JoinMethodAzure:
+		tokenName, err = generateAzureTokenName(req.Azure.Allow)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		t, err := clt.GetToken(r.Context(), tokenName)
+		if err != nil && !trace.IsNotFound(err) {
+			return nil, trace.Wrap(err)
+		}
+		if err == nil {
+			if t.GetJoinMethod() != types.JoinMethodAzure || !isSameAzureRuleSet(req.Azure.Allow, t.GetAzureRules()) {
+				return nil, trace.BadParameter("failed to create token: token with name %q already exists and does not have the expected allow rules", tokenName)
+			}
+			return &nodeJoinToken{
+				ID:     t.GetName(),
+				Expiry: *t.GetMetadata().Expires,
+				Method: t.GetJoinMethod(),
+			}, nil
+		}
+		expires = time.Now().UTC().AddDate(1000, 0, 0)
+	case types.JoinMethodGCP:
+		tokenName, err = generateGCPTokenName(req.GCP.Allow)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		t, err := clt.GetToken(r.Context(), tokenName)
+		if err != nil && !trace.IsNotFound(err) {
+			return nil, trace.Wrap(err)
+		}
+		if err == nil {
+			if t.GetJoinMethod() != types.JoinMethodGCP || !isSameGCPRuleSet(req.GCP.Allow, t.GetGCPRules()) {
+				return nil, trace.BadParameter("failed to create token: token with name %q already exists and does not have the expected allow rules", tokenName)
+			}
+			return &nodeJoinToken{
+				ID:     t.GetName(),
+				Expiry: *t.GetMetadata().Expires,
+				Method: t.GetJoinMethod(),
+			}, nil
+		}
+		expires = time.Now().UTC().AddDate(1000, 0, 0)
+	case types.JoinMethodKubernetes:
+		tokenName, err = generateKubernetesTokenName(req.Kubernetes.Allow)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		t, err := clt.GetToken(r.Context(), tokenName)
+		if err != nil && !trace.IsNotFound(err) {
+			return nil, trace.Wrap(err)
+		}
+		if err == nil {
+			if t.GetJoinMethod() != types.JoinMethodKubernetes || !isSameKubernetesRuleSet(req.Kubernetes.Allow, t.GetKubernetesRules()) {
+				return nil, trace.BadParameter("failed to create token: token with name %q already exists and does not have the expected allow rules", tokenName)
+			}
+			return &nodeJoinToken{
+				ID:     t.GetName(),
+				Expiry: *t.GetMetadata().Expires,
+				Method: t.GetJoinMethod(),
+			}, nil
+		}
+		expires = time.Now().UTC().AddDate(1000, 0, 0)
 	default:
 		tokenName, err = utils.CryptoRandomHex(auth.TokenLenBytes)
 		if err != nil {
@@ -223,6 +288,61 @@ func (h *Handler) getAppJoinScriptHandle(w http.ResponseWriter, r *http.Request,
 	return nil, nil
 }
 
+func (h *Handler) getKubeJoinScriptHandle(w http.ResponseWriter, r *http.Request, params httprouter.Params) (interface{}, error) {
+	scripts.SetScriptHeaders(w.Header())
+	queryValues := r.URL.Query()
+
+	kubeClusterName, err := url.QueryUnescape(queryValues.Get("kubeClusterName"))
+	if err != nil {
+		log.WithField("query-param", "kubeClusterName").WithError(err).Debug("Failed to return the Helm install script.")
+		w.Write(scripts.ErrorBashScript)
+		return nil, nil
+	}
+
+	releaseName, err := url.QueryUnescape(queryValues.Get("releaseName"))
+	if err != nil {
+		log.WithField("query-param", "releaseName").WithError(err).Debug("Failed to return the Helm install script.")
+		w.Write(scripts.ErrorBashScript)
+		return nil, nil
+	}
+	if releaseName == "" {
+		releaseName = "teleport-kube-agent"
+	}
+
+	namespace, err := url.QueryUnescape(queryValues.Get("namespace"))
+	if err != nil {
+		log.WithField("query-param", "namespace").WithError(err).Debug("Failed to return the Helm install script.")
+		w.Write(scripts.ErrorBashScript)
+		return nil, nil
+	}
+	if namespace == "" {
+		namespace = "teleport"
+	}
+
+	settings := scriptSettings{
+		token:           params.ByName("token"),
+		helmInstallMode: true,
+		kubeClusterName: kubeClusterName,
+		releaseName:     releaseName,
+		namespace:       namespace,
+	}
+
+	script, err := getJoinScript(r.Context(), settings, h.GetProxyClient())
+	if err != nil {
+		log.WithError(err).Info("Failed to return the Helm install script.")
+		w.Write(scripts.ErrorBashScript)
+		return nil, nil
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintln(w, script); err != nil {
+		log.WithError(err).Debug("Failed to return the Helm install script.")
+		w.Write(scripts.ErrorBashScript)
+	}
+
+	return nil, nil
+}
+
 func createJoinToken(ctx context.Context, m nodeAPIGetter, roles types.SystemRoles) (*nodeJoinToken, error) {
 	req := &proto.GenerateTokenRequest{
 		Roles: roles,
@@ -241,9 +361,13 @@ func createJoinToken(ctx context.Context, m nodeAPIGetter, roles types.SystemRol
 }
 
 func getJoinScript(ctx context.Context, settings scriptSettings, m nodeAPIGetter) (string, error) {
+	var provisionToken types.ProvisionToken
 	switch settings.joinMethod {
-	case string(types.JoinMethodUnspecified), string(types.JoinMethodToken), string(types.JoinMethodIAM):
-		if settings.joinMethod != string(types.JoinMethodIAM) {
+	case string(types.JoinMethodUnspecified), string(types.JoinMethodToken), string(types.JoinMethodIAM),
+		string(types.JoinMethodAzure), string(types.JoinMethodGCP), string(types.JoinMethodKubernetes):
+		// Only plain token-join names are random hex; IAM/Azure/GCP/Kubernetes
+		// tokens are given a deterministic name derived from their rule set.
+		if settings.joinMethod == string(types.JoinMethodUnspecified) || settings.joinMethod == string(types.JoinMethodToken) {
 			decodedToken, err := hex.DecodeString(settings.token)
 			if err != nil {
 				return "", trace.Wrap(err)
@@ -255,7 +379,8 @@ func getJoinScript(ctx context.Context, settings scriptSettings, m nodeAPIGetter
 
 		// The provided token can be attacker controlled, so we must validate
 		// it with the backend before using it to generate the script.
-		_, err := m.GetToken(ctx, settings.token)
+		var err error
+		provisionToken, err = m.GetToken(ctx, settings.token)
 		if err != nil {
 			return "", trace.BadParameter("invalid token")
 		}
@@ -263,6 +388,13 @@ func getJoinScript(ctx context.Context, settings scriptSettings, m nodeAPIGetter
 		return "", trace.BadParameter("join method %q is not supported via script", settings.joinMethod)
 	}
 
+	if settings.helmInstallMode {
+		if !provisionToken.GetRoles().Include(types.RoleKube) {
+			return "", trace.BadParameter("token %q cannot be used to join a Kubernetes cluster", settings.token)
+		}
+		return getHelmInstallScript(settings)
+	}
+
 	// We must also validate the label spec, which can be controlled by
 	// an attacker and is fed into the join script.
 	if _, err := client.ParseLabelSpec(settings.nodeLabels); err != nil {
@@ -336,6 +468,36 @@ func getJoinScript(ctx context.Context, settings scriptSettings, m nodeAPIGetter
 	return buf.String(), nil
 }
 
+// getHelmInstallScript validates settings' Helm-specific fields and renders
+// the `helm upgrade --install` snippet a browser can run to enroll a
+// Kubernetes cluster, mirroring how getJoinScript renders install.sh for
+// SSH nodes and app agents.
+func getHelmInstallScript(settings scriptSettings) (string, error) {
+	if errs := validation.IsDNS1035Label(settings.releaseName); len(errs) > 0 {
+		return "", trace.BadParameter("releaseName %q must be a valid DNS subdomain: %s", settings.releaseName, errs)
+	}
+	if errs := validation.IsDNS1035Label(settings.namespace); len(errs) > 0 {
+		return "", trace.BadParameter("namespace %q must be a valid DNS subdomain: %s", settings.namespace, errs)
+	}
+	if settings.kubeClusterName == "" {
+		return "", trace.BadParameter("kubeClusterName is required")
+	}
+
+	var buf bytes.Buffer
+	err := scripts.InstallKubeHelmScript.Execute(&buf, map[string]string{
+		"token":           settings.token,
+		"releaseName":     settings.releaseName,
+		"namespace":       settings.namespace,
+		"kubeClusterName": settings.kubeClusterName,
+		"joinMethod":      string(types.JoinMethodToken),
+	})
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	return buf.String(), nil
+}
+
 // generateIAMTokenName makes a deterministic name for a iam join token
 // based on its rule set
 func generateIAMTokenName(rules []*types.TokenRule) (string, error) {
@@ -379,6 +541,123 @@ func isSameRuleSet(r1 []*types.TokenRule, r2 []*types.TokenRule) bool {
 	return reflect.DeepEqual(r1, r2)
 }
 
+// generateAzureTokenName makes a deterministic name for an Azure join token
+// based on its rule set, the Azure equivalent of generateIAMTokenName.
+func generateAzureTokenName(rules []*types.ProvisionTokenSpecV2Azure_Rule) (string, error) {
+	orderedRules := make([]*types.ProvisionTokenSpecV2Azure_Rule, len(rules))
+	copy(orderedRules, rules)
+	sortAzureRules(orderedRules)
+
+	h := fnv.New32a()
+	for _, r := range orderedRules {
+		resourceGroups := make([]string, len(r.ResourceGroups))
+		copy(resourceGroups, r.ResourceGroups)
+		sort.Strings(resourceGroups)
+		s := fmt.Sprintf("%s%s", r.Subscription, strings.Join(resourceGroups, ","))
+		if _, err := h.Write([]byte(s)); err != nil {
+			return "", trace.Wrap(err)
+		}
+	}
+
+	return fmt.Sprintf("teleport-ui-azure-%d", h.Sum32()), nil
+}
+
+// sortAzureRules sorts a slice of Azure rules by subscription, the Azure
+// equivalent of sortRules.
+func sortAzureRules(rules []*types.ProvisionTokenSpecV2Azure_Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Subscription < rules[j].Subscription
+	})
+}
+
+// isSameAzureRuleSet checks if r1 and r2 are the same rules, ignoring order.
+func isSameAzureRuleSet(r1, r2 []*types.ProvisionTokenSpecV2Azure_Rule) bool {
+	sortAzureRules(r1)
+	sortAzureRules(r2)
+	return reflect.DeepEqual(r1, r2)
+}
+
+// generateGCPTokenName makes a deterministic name for a GCP join token
+// based on its rule set, the GCP equivalent of generateIAMTokenName.
+func generateGCPTokenName(rules []*types.ProvisionTokenSpecV2GCP_Rule) (string, error) {
+	orderedRules := make([]*types.ProvisionTokenSpecV2GCP_Rule, len(rules))
+	copy(orderedRules, rules)
+	sortGCPRules(orderedRules)
+
+	h := fnv.New32a()
+	for _, r := range orderedRules {
+		projectIDs := make([]string, len(r.ProjectIDs))
+		copy(projectIDs, r.ProjectIDs)
+		sort.Strings(projectIDs)
+		serviceAccounts := make([]string, len(r.ServiceAccounts))
+		copy(serviceAccounts, r.ServiceAccounts)
+		sort.Strings(serviceAccounts)
+		s := fmt.Sprintf("%s%s", strings.Join(projectIDs, ","), strings.Join(serviceAccounts, ","))
+		if _, err := h.Write([]byte(s)); err != nil {
+			return "", trace.Wrap(err)
+		}
+	}
+
+	return fmt.Sprintf("teleport-ui-gcp-%d", h.Sum32()), nil
+}
+
+// sortGCPRules sorts a slice of GCP rules by their comma-joined project IDs,
+// the GCP equivalent of sortRules.
+func sortGCPRules(rules []*types.ProvisionTokenSpecV2GCP_Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		iProjects, jProjects := strings.Join(rules[i].ProjectIDs, ","), strings.Join(rules[j].ProjectIDs, ",")
+		return iProjects < jProjects
+	})
+}
+
+// isSameGCPRuleSet checks if r1 and r2 are the same rules, ignoring order.
+func isSameGCPRuleSet(r1, r2 []*types.ProvisionTokenSpecV2GCP_Rule) bool {
+	sortGCPRules(r1)
+	sortGCPRules(r2)
+	return reflect.DeepEqual(r1, r2)
+}
+
+// generateKubernetesTokenName makes a deterministic name for a Kubernetes
+// join token based on its rule set, the Kubernetes equivalent of
+// generateIAMTokenName.
+func generateKubernetesTokenName(rules []*types.ProvisionTokenSpecV2Kubernetes_Rule) (string, error) {
+	orderedRules := make([]*types.ProvisionTokenSpecV2Kubernetes_Rule, len(rules))
+	copy(orderedRules, rules)
+	sortKubernetesRules(orderedRules)
+
+	h := fnv.New32a()
+	for _, r := range orderedRules {
+		namespaces := make([]string, len(r.Namespaces))
+		copy(namespaces, r.Namespaces)
+		sort.Strings(namespaces)
+		names := make([]string, len(r.ServiceAccountNames))
+		copy(names, r.ServiceAccountNames)
+		sort.Strings(names)
+		s := fmt.Sprintf("%s%s", strings.Join(namespaces, ","), strings.Join(names, ","))
+		if _, err := h.Write([]byte(s)); err != nil {
+			return "", trace.Wrap(err)
+		}
+	}
+
+	return fmt.Sprintf("teleport-ui-kube-%d", h.Sum32()), nil
+}
+
+// sortKubernetesRules sorts a slice of Kubernetes rules by their namespaces,
+// the Kubernetes equivalent of sortRules.
+func sortKubernetesRules(rules []*types.ProvisionTokenSpecV2Kubernetes_Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return strings.Join(rules[i].Namespaces, ",") < strings.Join(rules[j].Namespaces, ",")
+	})
+}
+
+// isSameKubernetesRuleSet checks if r1 and r2 are the same rules, ignoring
+// order.
+func isSameKubernetesRuleSet(r1, r2 []*types.ProvisionTokenSpecV2Kubernetes_Rule) bool {
+	sortKubernetesRules(r1)
+	sortKubernetesRules(r2)
+	return reflect.DeepEqual(r1, r2)
+}
+
 type nodeAPIGetter interface {
 	// GenerateToken creates a special provisioning token for a new SSH server.
 	//
@@ -32,6 +32,17 @@ const defaultIdentityFileName = "identity"
 // file that can be used by tsh and tctl.
 type TemplateIdentity struct {
 	FileName string `yaml:"file_name,omitempty"`
+
+	// SCEP configures an alternate renewal transport for bots that can only
+	// reach a SCEP/EST endpoint (e.g. behind a device-management proxy) and
+	// cannot call back to the Auth server directly. When unset, Render falls
+	// back to the default auth-client-backed renewal.
+	SCEP *SCEPRenewalConfig `yaml:"scep,omitempty"`
+
+	// transport is the RenewalTransport used by Render. It is derived from
+	// SCEP in CheckAndSetDefaults, or left nil to use the default
+	// auth-client-backed renewal.
+	transport RenewalTransport
 }
 
 func (t *TemplateIdentity) CheckAndSetDefaults() error {
@@ -39,6 +50,13 @@ func (t *TemplateIdentity) CheckAndSetDefaults() error {
 		t.FileName = defaultIdentityFileName
 	}
 
+	if t.SCEP != nil {
+		if err := t.SCEP.CheckAndSetDefaults(); err != nil {
+			return trace.Wrap(err)
+		}
+		t.transport = NewSCEPTransport(*t.SCEP)
+	}
+
 	return nil
 }
 
@@ -65,6 +83,17 @@ func (t *TemplateIdentity) Render(ctx context.Context, authClient auth.ClientI,
 		return trace.Wrap(err)
 	}
 
+	if t.transport != nil {
+		renewedCert, err := t.transport.Renew(ctx, currentIdentity, hostCAs)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		currentIdentity, err = identity.ReadIdentityFromKeyPair(currentIdentity.PrivateKeyBytes(), renewedCert)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
 	cfg := identityfile.WriteConfig{
 		OutputPath: t.FileName,
 		Writer: &BotConfigWriter{
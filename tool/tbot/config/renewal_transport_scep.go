@@ -0,0 +1,264 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"net/http"
+
+	"go.mozilla.org/pkcs7"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/tool/tbot/identity"
+	"github.com/gravitational/trace"
+)
+
+// scepOperationPKIOperation is the SCEP message type for a certificate
+// signing request, per RFC 8894.
+const scepOperationPKIOperation = "PKIOperation"
+
+// RenewalTransport renews a bot identity's certificate by some transport
+// other than calling back to the Auth server directly. TemplateIdentity
+// dispatches to one when configured, falling back to the default
+// auth-client-backed renewal otherwise.
+type RenewalTransport interface {
+	// Renew generates a CSR from currentIdentity's existing key, submits it
+	// over the transport, validates the response against the supplied host
+	// CAs, and returns the renewed leaf certificate.
+	Renew(ctx context.Context, currentIdentity *identity.Identity, hostCAs []types.CertAuthority) (*x509.Certificate, error)
+}
+
+// SCEPRenewalConfig configures a SCEPTransport.
+type SCEPRenewalConfig struct {
+	// URL is the SCEP server's pkiclient endpoint, e.g.
+	// https://scep.example.com/scep.
+	URL string `yaml:"url"`
+	// ChallengePassword is the shared-secret enrollment challenge some SCEP
+	// servers require in the CSR's challengePassword attribute.
+	ChallengePassword string `yaml:"challenge_password,omitempty"`
+	// SignerCA is the PEM-encoded certificate of the CA the SCEP server signs
+	// its CertRep messages with. It is used to validate the response when the
+	// server is operating in RA mode and the degenerate PKCS7 returned by the
+	// server carries no intermediate chain.
+	SignerCA string `yaml:"signer_ca,omitempty"`
+}
+
+// CheckAndSetDefaults validates the SCEP renewal config.
+func (c *SCEPRenewalConfig) CheckAndSetDefaults() error {
+	if c.URL == "" {
+		return trace.BadParameter("scep: url is required")
+	}
+	return nil
+}
+
+// SCEPTransport renews a bot identity by submitting a PKCS#7-wrapped CSR to
+// a SCEP server and checking that the CertRep's signer certificate chains to
+// pinned root/intermediate CAs. The returned leaf certificate itself is
+// checked only for its SANs and CommonName, not chain-verified.
+type SCEPTransport struct {
+	cfg        SCEPRenewalConfig
+	httpClient *http.Client
+}
+
+// NewSCEPTransport creates a SCEPTransport from cfg.
+func NewSCEPTransport(cfg SCEPRenewalConfig) *SCEPTransport {
+	return &SCEPTransport{
+		cfg:        cfg,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Renew implements RenewalTransport.
+func (t *SCEPTransport) Renew(ctx context.Context, currentIdentity *identity.Identity, hostCAs []types.CertAuthority) (*x509.Certificate, error) {
+	signerCert, signerKey, err := currentIdentity.TLSCertAndSigner()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	csrDER, err := buildCSR(currentIdentity, t.cfg.ChallengePassword)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	// Wrap the CSR in a degenerate (signer-only, no recipient-specific
+	// encryption) PKCS#7 envelope signed by the previous certificate, as
+	// RFC 8894 requires for PKIOperation requests.
+	signedData, err := pkcs7.NewSignedData(csrDER)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := signedData.AddSigner(signerCert, signerKey, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	envelope, err := signedData.Finish()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.URL+"?operation="+scepOperationPKIOperation, bytes.NewReader(envelope))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/x-pki-message")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, trace.BadParameter("scep server returned status %d", resp.StatusCode)
+	}
+
+	p7, err := pkcs7.Parse(readAll(resp.Body))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	// RA-mode responders may omit intermediates from the chain; fall back
+	// to the configured root as the trusted signer in that case.
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		signer, err = parseSignerCAPEM(t.cfg.SignerCA)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	pool := x509.NewCertPool()
+	for _, ca := range hostCAs {
+		for _, keyPair := range ca.GetTrustedTLSKeyPairs() {
+			pool.AppendCertsFromPEM(keyPair.Cert)
+		}
+	}
+	if t.cfg.SignerCA != "" {
+		pool.AppendCertsFromPEM([]byte(t.cfg.SignerCA))
+	}
+
+	if _, err := signer.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		return nil, trace.Wrap(err, "scep signer did not chain to a pinned CA")
+	}
+
+	if len(p7.Certificates) == 0 {
+		return nil, trace.BadParameter("scep CertRep contained no certificates")
+	}
+	leaf := p7.Certificates[0]
+
+	// Enforce that the renewed certificate still asserts the bot's own
+	// identity, so a malicious or misconfigured SCEP responder can't swap
+	// in a certificate for a different identity.
+	if err := verifySANsMatch(leaf, currentIdentity); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return leaf, nil
+}
+
+func parseSignerCAPEM(pemData string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, trace.BadParameter("scep: signer_ca is not valid PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return cert, nil
+}
+
+// verifySANsMatch ensures the renewed leaf certificate's subject alternative
+// names are exactly the identity's own set, and that its CommonName still
+// names the bot, preventing a compromised SCEP responder from substituting
+// an attacker-controlled identity, or a subset of the bot's SANs, or an
+// empty SAN set entirely.
+func verifySANsMatch(leaf *x509.Certificate, currentIdentity *identity.Identity) error {
+	expected := currentIdentity.SANs()
+	got := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		got = append(got, ip.String())
+	}
+
+	remaining := make(map[string]int, len(expected))
+	for _, san := range expected {
+		remaining[san]++
+	}
+	for _, san := range got {
+		if remaining[san] == 0 {
+			return trace.BadParameter("renewed certificate SAN %q does not match bot identity", san)
+		}
+		remaining[san]--
+	}
+	for san, count := range remaining {
+		if count > 0 {
+			return trace.BadParameter("renewed certificate is missing bot identity SAN %q", san)
+		}
+	}
+
+	if leaf.Subject.CommonName != currentIdentity.Name() {
+		return trace.BadParameter("renewed certificate CommonName %q does not match bot identity %q", leaf.Subject.CommonName, currentIdentity.Name())
+	}
+	return nil
+}
+
+// buildCSR generates a PKCS#10 CSR from currentIdentity's existing key,
+// carrying the SCEP challenge password as the standard CSR attribute.
+func buildCSR(currentIdentity *identity.Identity, challengePassword string) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: currentIdentity.Name(),
+		},
+	}
+	if challengePassword != "" {
+		if err := addChallengePasswordAttribute(template, challengePassword); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	_, signer, err := currentIdentity.TLSCertAndSigner()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, template, signer)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return csrDER, nil
+}
+
+// challengePasswordOID is the PKCS#9 challengePassword attribute OID.
+var challengePasswordOID = []int{1, 2, 840, 113549, 1, 9, 7}
+
+func addChallengePasswordAttribute(template *x509.CertificateRequest, password string) error {
+	template.Subject.ExtraNames = append(template.Subject.ExtraNames, pkix.AttributeTypeAndValue{
+		Type:  challengePasswordOID,
+		Value: password,
+	})
+	return nil
+}
+
+func readAll(r io.Reader) []byte {
+	data, _ := io.ReadAll(r)
+	return data
+}
@@ -2,25 +2,67 @@ package common
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
 	"github.com/gravitational/kingpin"
+	"github.com/gravitational/trace"
+	"gopkg.in/yaml.v2"
+
 	"github.com/gravitational/teleport/lib/auth"
+	"github.com/gravitational/teleport/lib/client"
 	"github.com/gravitational/teleport/lib/service"
-	"github.com/gravitational/trace"
+)
+
+// Supported values for the proxy ls --format flag.
+const (
+	textFormat = "text"
+	jsonFormat = "json"
+	yamlFormat = "yaml"
 )
 
 // ProxyCommand returns information about connected proxies
 type ProxyCommand struct {
 	config *service.Config
-	lsCmd  *kingpin.CmdClause
+
+	lsCmd      *kingpin.CmdClause
+	inspectCmd *kingpin.CmdClause
+	peersCmd   *kingpin.CmdClause
+
+	format     string
+	proxyName  string
+	localAgent *client.LocalKeyAgent
 }
 
 // Initialize creates the proxy command and subcommands
 func (p *ProxyCommand) Initialize(app *kingpin.Application, config *service.Config) {
 	p.config = config
 
-	auth := app.Command("proxy", "Operations with information for cluster proxies").Hidden()
-	p.lsCmd = auth.Command("ls", "List connected auth servers")
+	proxy := app.Command("proxy", "Operations with information for cluster proxies").Hidden()
+
+	p.lsCmd = proxy.Command("ls", "List connected proxies")
+	p.lsCmd.Flag("format", "Output format: text, json, or yaml").Default(textFormat).StringVar(&p.format)
+
+	p.inspectCmd = proxy.Command("inspect", "Open an SSH control connection through a proxy and report its host cert")
+	p.inspectCmd.Arg("name", "Name of the proxy to inspect").Required().StringVar(&p.proxyName)
+
+	p.peersCmd = proxy.Command("peers", "List proxy-to-proxy peer tunnels and their RTT")
+}
+
+// proxyRow is the output shape for `proxy ls`.
+type proxyRow struct {
+	Name          string    `json:"name" yaml:"name"`
+	Version       string    `json:"version" yaml:"version"`
+	TunnelAddr    string    `json:"tunnel_addr" yaml:"tunnel_addr"`
+	PublicAddr    string    `json:"public_addr" yaml:"public_addr"`
+	PeerState     string    `json:"peer_state" yaml:"peer_state"`
+	LastHeartbeat time.Time `json:"last_heartbeat" yaml:"last_heartbeat"`
 }
 
 // ListProxies prints currently connected proxies
@@ -30,21 +72,154 @@ func (p *ProxyCommand) ListProxies(ctx context.Context, clusterAPI auth.ClientI)
 		return trace.Wrap(err)
 	}
 
+	rows := make([]proxyRow, 0, len(proxies))
 	for _, proxy := range proxies {
-		fmt.Printf("%s\n", proxy.GetName())
-		fmt.Printf("%s\n", proxy.GetAddr())
-		fmt.Printf("%s\n", proxy.GetHostname())
+		rows = append(rows, proxyRow{
+			Name:          proxy.GetName(),
+			Version:       proxy.GetTeleportVersion(),
+			TunnelAddr:    proxy.GetAddr(),
+			PublicAddr:    proxy.GetPublicAddr(),
+			PeerState:     proxyPeerState(proxy),
+			LastHeartbeat: proxy.GetLastHeartbeat(),
+		})
+	}
+
+	return p.printProxies(rows)
+}
+
+// proxyPeerState reports "connected" while the proxy's heartbeat has not
+// expired and "stale" once it has. It does not check proxy-to-proxy peer
+// tunnels.
+func proxyPeerState(proxy interface{ Expiry() time.Time }) string {
+	if time.Until(proxy.Expiry()) <= 0 {
+		return "stale"
+	}
+	return "connected"
+}
 
-		fmt.Println()
+func (p *ProxyCommand) printProxies(rows []proxyRow) error {
+	switch p.format {
+	case jsonFormat:
+		return trace.Wrap(json.NewEncoder(os.Stdout).Encode(rows))
+	case yamlFormat:
+		return trace.Wrap(yaml.NewEncoder(os.Stdout).Encode(rows))
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Name\tVersion\tTunnel Addr\tPublic Addr\tPeer State\tLast Heartbeat")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				row.Name, row.Version, row.TunnelAddr, row.PublicAddr, row.PeerState, row.LastHeartbeat.Format(time.RFC3339))
+		}
+		return trace.Wrap(w.Flush())
+	}
+}
+
+// InspectProxy opens an SSH control connection through the named proxy and
+// reports the negotiated cipher, host cert principals, and CA fingerprint,
+// mirroring the verification performed automatically by LocalKeyAgent when
+// dialing a proxy.
+func (p *ProxyCommand) InspectProxy(ctx context.Context, clusterAPI auth.ClientI) error {
+	proxies, err := clusterAPI.GetProxies()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	var target interface {
+		GetName() string
+		GetAddr() string
+	}
+	for _, proxy := range proxies {
+		if proxy.GetName() == p.proxyName {
+			target = proxy
+			break
+		}
+	}
+	if target == nil {
+		return trace.NotFound("proxy %q not found", p.proxyName)
 	}
+
+	if p.localAgent == nil {
+		localAgent, err := newLocalAgentForInspect(target.GetAddr())
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		p.localAgent = localAgent
+	}
+
+	report, err := inspectProxyHostCert(ctx, p.localAgent, target.GetAddr())
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	fmt.Printf("Proxy:       %s\n", target.GetName())
+	fmt.Printf("Cipher:      %s\n", report.Cipher)
+	fmt.Printf("Principals:  %v\n", report.Principals)
+	fmt.Printf("CA fingerprint: %s\n", report.CAFingerprint)
 	return nil
 }
 
+// newLocalAgentForInspect builds a LocalKeyAgent against the default local
+// profile store so `tctl proxy inspect` can verify the target proxy's host
+// certificate. This is an admin diagnostic rather than part of the usual
+// `tsh login` flow that normally owns LocalKeyAgent construction, so it has
+// no existing session keys to reuse and falls back to the OS user and the
+// default ~/.tsh profile directory.
+func newLocalAgentForInspect(proxyAddr string) (*client.LocalKeyAgent, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	keystore, err := client.NewFSLocalKeyStore(filepath.Join(home, ".tsh"))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	proxyHost, _, err := net.SplitHostPort(proxyAddr)
+	if err != nil {
+		proxyHost = proxyAddr
+	}
+
+	lka, err := client.NewLocalAgent(client.LocalAgentConfig{
+		Keystore:  keystore,
+		ProxyHost: proxyHost,
+		Username:  currentUser.Username,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return lka, nil
+}
+
+// ListPeers prints the RTT of each proxy-to-proxy peer tunnel.
+func (p *ProxyCommand) ListPeers(ctx context.Context, clusterAPI auth.ClientI) error {
+	peers, err := listProxyPeers(ctx, clusterAPI)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Proxy\tPeer\tRTT")
+	for _, peer := range peers {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", peer.Proxy, peer.Peer, peer.RTT)
+	}
+	return trace.Wrap(w.Flush())
+}
+
 // TryRun runs the proxy command
 func (p *ProxyCommand) TryRun(ctx context.Context, cmd string, client auth.ClientI) (match bool, err error) {
 	switch cmd {
 	case p.lsCmd.FullCommand():
 		err = p.ListProxies(ctx, client)
+	case p.inspectCmd.FullCommand():
+		err = p.InspectProxy(ctx, client)
+	case p.peersCmd.FullCommand():
+		err = p.ListPeers(ctx, client)
+	default:
 		return false, nil
 	}
 	return true, trace.Wrap(err)
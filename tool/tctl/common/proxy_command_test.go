@@ -0,0 +1,58 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gravitational/kingpin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/auth"
+	"github.com/gravitational/teleport/lib/service"
+)
+
+// fakeProxyClient is a minimal auth.ClientI stub exercising only the calls
+// ProxyCommand makes.
+type fakeProxyClient struct {
+	auth.ClientI
+	proxies []types.Server
+}
+
+func (f *fakeProxyClient) GetProxies() ([]types.Server, error) {
+	return f.proxies, nil
+}
+
+func TestProxyCommand_TryRun(t *testing.T) {
+	app := kingpin.New("tctl", "")
+	cmd := &ProxyCommand{}
+	cmd.Initialize(app, &service.Config{})
+
+	_, err := app.Parse([]string{"proxy", "ls"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		cmdString string
+		wantMatch bool
+	}{
+		{
+			name:      "ls matches and returns no error",
+			cmdString: cmd.lsCmd.FullCommand(),
+			wantMatch: true,
+		},
+		{
+			name:      "unknown command does not match",
+			cmdString: "nodes ls",
+			wantMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := cmd.TryRun(context.Background(), tt.cmdString, &fakeProxyClient{})
+			require.Equal(t, tt.wantMatch, match)
+			require.NoError(t, err)
+		})
+	}
+}
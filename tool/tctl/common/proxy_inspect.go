@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gravitational/teleport/lib/auth"
+	"github.com/gravitational/teleport/lib/client"
+	"github.com/gravitational/trace"
+)
+
+// hostCertReport summarizes the host certificate presented by a proxy's SSH
+// control connection, mirroring the checks LocalKeyAgent.CheckHostSignature
+// performs automatically when dialing a node.
+type hostCertReport struct {
+	Cipher        string
+	Principals    []string
+	CAFingerprint string
+}
+
+// inspectProxyHostCert opens an SSH control connection to addr using lka for
+// authentication and reports the negotiated cipher and the presented host
+// certificate's principals and CA fingerprint.
+func inspectProxyHostCert(ctx context.Context, lka *client.LocalKeyAgent, addr string) (*hostCertReport, error) {
+	var report hostCertReport
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		Timeout: 10 * time.Second,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			cert, ok := key.(*ssh.Certificate)
+			if !ok {
+				return trace.BadParameter("proxy did not present a host certificate")
+			}
+			report.Principals = cert.ValidPrincipals
+			report.CAFingerprint = fingerprintSSHPublicKey(cert.SignatureKey)
+			return lka.CheckHostSignature(hostname, remote, key)
+		},
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer conn.Close()
+
+	// golang.org/x/crypto/ssh does not expose the negotiated cipher suite
+	// through the public ssh.Conn interface, so this is best-effort: it
+	// only populates Cipher when the underlying connection happens to
+	// implement the unexported accessor some transport wrappers add.
+	if c, ok := conn.Conn.(interface{ Cipher() string }); ok {
+		report.Cipher = c.Cipher()
+	}
+
+	return &report, nil
+}
+
+// fingerprintSSHPublicKey returns a base64 SHA256 fingerprint of key, in the
+// same format `ssh-keygen -lf` uses.
+func fingerprintSSHPublicKey(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// proxyPeer describes a single proxy-to-proxy peer tunnel, as reported by
+// `proxy peers`.
+type proxyPeer struct {
+	Proxy string
+	Peer  string
+	RTT   time.Duration
+}
+
+// listProxyPeers enumerates the peer tunnels reported by every connected
+// proxy and measures round-trip time via the lightweight peer ping RPC.
+func listProxyPeers(ctx context.Context, clusterAPI auth.ClientI) ([]proxyPeer, error) {
+	proxies, err := clusterAPI.GetProxies()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var peers []proxyPeer
+	for _, proxy := range proxies {
+		for _, peerName := range proxy.GetPeerAddrs() {
+			start := time.Now()
+			// PingPeer is expected to round-trip a small ping RPC over the
+			// existing peer tunnel; a failure is reported as a zero RTT
+			// rather than aborting the whole listing.
+			rtt := time.Duration(0)
+			if err := clusterAPI.PingPeer(ctx, proxy.GetName(), peerName); err == nil {
+				rtt = time.Since(start)
+			}
+			peers = append(peers, proxyPeer{
+				Proxy: proxy.GetName(),
+				Peer:  peerName,
+				RTT:   rtt,
+			})
+		}
+	}
+	return peers, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/gravitational/kingpin"
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/gravitational/teleport/lib/client"
+)
+
+// agentCommand implements "tsh agent lock|unlock", letting a user freeze
+// and resume use of their loaded certificate without unloading the key
+// material from the agent.
+type agentCommand struct {
+	lockCmd   *kingpin.CmdClause
+	unlockCmd *kingpin.CmdClause
+}
+
+// newAgentCommand creates the "agent" subcommand and its children.
+func newAgentCommand(app *kingpin.Application) *agentCommand {
+	cmd := &agentCommand{}
+	agent := app.Command("agent", "Manage the local Teleport SSH agent.")
+	cmd.lockCmd = agent.Command("lock", "Temporarily lock the agent so it refuses to sign or list keys.")
+	cmd.unlockCmd = agent.Command("unlock", "Unlock a previously locked agent.")
+	return cmd
+}
+
+// lock prompts for a passphrase and locks the local key agent with it.
+func (c *agentCommand) lock(lka *client.LocalKeyAgent) error {
+	passphrase, err := readAgentPassphrase("Enter a passphrase to lock the agent: ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := lka.Lock(passphrase); err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Println("Agent locked.")
+	return nil
+}
+
+// unlock prompts for the passphrase used to lock the agent and unlocks it.
+func (c *agentCommand) unlock(lka *client.LocalKeyAgent) error {
+	passphrase, err := readAgentPassphrase("Enter the passphrase used to lock the agent: ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := lka.Unlock(passphrase); err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Println("Agent unlocked.")
+	return nil
+}
+
+// readAgentPassphrase reads a passphrase from the controlling terminal
+// without echoing it back.
+func readAgentPassphrase(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+	passphrase, err := terminal.ReadPassword(0)
+	fmt.Println()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gravitational/kingpin"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/teleport/lib/client"
+)
+
+// gitSSHCommand implements "tsh git-ssh", which prints an ssh invocation
+// suitable for GIT_SSH_COMMAND, and "tsh git-ssh export-signing-key", which
+// writes out the public key/certificate pair used for commit signing.
+type gitSSHCommand struct {
+	cmd           *kingpin.CmdClause
+	exportCmd     *kingpin.CmdClause
+	exportSigning bool
+}
+
+func newGitSSHCommand(app *kingpin.Application) *gitSSHCommand {
+	c := &gitSSHCommand{}
+	c.cmd = app.Command("git-ssh", "Print a GIT_SSH_COMMAND that signs with the current Teleport identity.")
+	c.exportCmd = c.cmd.Command("export-signing-key", "Export the current Teleport identity as a git signing key pair.")
+	return c
+}
+
+func (c *gitSSHCommand) run(ctx context.Context, lka *client.LocalKeyAgent) error {
+	command, err := lka.GitSSHCommand(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Println(command)
+	return nil
+}
+
+func (c *gitSSHCommand) runExport(lka *client.LocalKeyAgent) error {
+	certPath, err := lka.ExportSSHSigningKey()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Printf("Signing key exported. Set the following in your git config:\n\n")
+	fmt.Printf("  git config --global gpg.format ssh\n")
+	fmt.Printf("  git config --global user.signingkey %s\n", certPath)
+	return nil
+}
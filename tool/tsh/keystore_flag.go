@@ -0,0 +1,51 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/gravitational/kingpin"
+	"github.com/gravitational/trace"
+)
+
+// keystoreFlags holds the CLI state backing "tsh --keystore-encrypted",
+// which tells tsh to wrap the on-disk key store with
+// client.NewEncryptedFSLocalKeyStore instead of the plaintext
+// client.FSLocalKeyStore.
+type keystoreFlags struct {
+	encrypted bool
+}
+
+// registerKeystoreFlags registers the --keystore-encrypted flag on app. The
+// returned keystoreFlags is populated once app.Parse has run.
+func registerKeystoreFlags(app *kingpin.Application) *keystoreFlags {
+	f := &keystoreFlags{}
+	app.Flag("keystore-encrypted", "Encrypt private keys at rest with a passphrase-derived key.").BoolVar(&f.encrypted)
+	return f
+}
+
+// passphrase prompts for the keystore passphrase when encryption was
+// requested, returning nil if --keystore-encrypted was not set.
+func (f *keystoreFlags) passphrase() ([]byte, error) {
+	if !f.encrypted {
+		return nil, nil
+	}
+	passphrase, err := readAgentPassphrase("Enter a passphrase to encrypt the local keystore: ")
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return passphrase, nil
+}
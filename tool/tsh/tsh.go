@@ -0,0 +1,138 @@
+/*
+Copyright 2022 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/gravitational/kingpin"
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/teleport/lib/client"
+)
+
+// proxyEnvVar is consulted to learn which proxy the local agent operates
+// against. tsh normally gets this from the current login profile; this
+// package doesn't implement profile management, so it falls back to the
+// environment the same way `tsh login --proxy` callers already set it up.
+const proxyEnvVar = "TELEPORT_PROXY"
+
+// Run parses args against the tsh command tree and dispatches to the
+// matched command.
+func Run(args []string) error {
+	app := kingpin.New("tsh", "The Teleport CLI client.")
+
+	ksFlags := registerKeystoreFlags(app)
+	agentCmd := newAgentCommand(app)
+	gitSSHCmd := newGitSSHCommand(app)
+
+	selected, err := app.Parse(args)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	ctx := context.Background()
+
+	switch selected {
+	case agentCmd.lockCmd.FullCommand():
+		lka, err := newLocalAgent(ksFlags)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(agentCmd.lock(lka))
+	case agentCmd.unlockCmd.FullCommand():
+		lka, err := newLocalAgent(ksFlags)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(agentCmd.unlock(lka))
+	case gitSSHCmd.cmd.FullCommand():
+		lka, err := newLocalAgent(ksFlags)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(gitSSHCmd.run(ctx, lka))
+	case gitSSHCmd.exportCmd.FullCommand():
+		lka, err := newLocalAgent(ksFlags)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(gitSSHCmd.runExport(lka))
+	}
+	return trace.BadParameter("unrecognized command %q", selected)
+}
+
+// newKeystore constructs the plaintext or passphrase-encrypted local key
+// store for dir, depending on whether --keystore-encrypted was passed.
+func newKeystore(ksFlags *keystoreFlags, dir string) (client.LocalKeyStore, error) {
+	passphrase, err := ksFlags.passphrase()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if passphrase == nil {
+		keystore, err := client.NewFSLocalKeyStore(dir)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return keystore, nil
+	}
+
+	keystore, err := client.NewEncryptedFSLocalKeyStore(dir, passphrase)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return keystore, nil
+}
+
+// newLocalAgent builds a LocalKeyAgent against the default local profile
+// store, wrapping it with an encrypted keystore if --keystore-encrypted was
+// passed.
+func newLocalAgent(ksFlags *keystoreFlags) (*client.LocalKeyAgent, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	keystore, err := newKeystore(ksFlags, filepath.Join(home, ".tsh"))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	proxyHost, _, err := net.SplitHostPort(os.Getenv(proxyEnvVar))
+	if err != nil {
+		proxyHost = os.Getenv(proxyEnvVar)
+	}
+
+	lka, err := client.NewLocalAgent(client.LocalAgentConfig{
+		Keystore:  keystore,
+		ProxyHost: proxyHost,
+		Username:  currentUser.Username,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return lka, nil
+}